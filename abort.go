@@ -0,0 +1,23 @@
+package keystone
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// the host functions that trap the wasm call when keystone can not continue,
+// the panic is recovered by wazero and returned from api.Function.Call.
+
+func cxaThrow(_ context.Context, _ api.Module, _, _, _ int32) {
+	panic(&abortError{reason: "C++ exception thrown"})
+}
+
+func abortJS(context.Context, api.Module) {
+	panic(&abortError{reason: "abort called"})
+}
+
+func exit(_ context.Context, _ api.Module, code int32) {
+	panic(&abortError{reason: fmt.Sprintf("exit called with code %d", code)})
+}
@@ -0,0 +1,37 @@
+package keystone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Aborted(t *testing.T) {
+	for _, item := range []*struct {
+		name string
+		src  string
+		msg  string
+	}{
+		{"cxa_throw", "throw", "keystone engine is aborted: C++ exception thrown"},
+		{"abort", "abort", "keystone engine is aborted: abort called"},
+		{"exit", "exit", "keystone engine is aborted: exit called with code 1"},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			engine, err := newFakeEngine(nil)
+			require.NoError(t, err)
+
+			inst, err := engine.Assemble(item.src, 0)
+			require.ErrorIs(t, err, ErrEngineAborted)
+			require.EqualError(t, err, item.msg)
+			require.Nil(t, inst)
+
+			// the engine is recovered at the next call
+			inst, err = engine.Assemble("nop", 0)
+			require.NoError(t, err)
+			require.Equal(t, []byte{0xC3}, inst)
+
+			err = engine.Close()
+			require.NoError(t, err)
+		})
+	}
+}
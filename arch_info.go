@@ -0,0 +1,142 @@
+package keystone
+
+import (
+	"encoding/binary"
+)
+
+// ArchMetadata contains the metadata of an arch and mode combination.
+type ArchMetadata struct {
+	// PointerSize is the size of address in bytes,
+	// for EVM it is the size of stack word.
+	PointerSize int
+
+	// ByteOrder is the byte order of data and instructions.
+	ByteOrder binary.ByteOrder
+
+	// Alignment is the required alignment of instructions.
+	Alignment int
+
+	// FixedWidth is true if all instructions have the same size.
+	FixedWidth bool
+
+	// MinInstSize is the size of the shortest instruction.
+	MinInstSize int
+
+	// MaxInstSize is the size of the longest instruction.
+	MaxInstSize int
+
+	// NOP is the canonical encoding of "nop", it is nil for
+	// EVM that not have a nop instruction.
+	NOP []byte
+}
+
+// ArchInfo is used to get the metadata of an arch and mode combination,
+// the combination must be one of the SupportedTargets.
+func ArchInfo(arch Arch, mode Mode) (*ArchMetadata, error) {
+	err := checkTarget(arch, mode)
+	if err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if mode&MODE_BIG_ENDIAN != 0 {
+		order = binary.BigEndian
+	}
+	// the fixed 4 bytes instruction set
+	fixed := func(ptrSize int, nop uint32) *ArchMetadata {
+		b := make([]byte, 4)
+		order.PutUint32(b, nop)
+		return &ArchMetadata{
+			PointerSize: ptrSize,
+			ByteOrder:   order,
+			Alignment:   4,
+			FixedWidth:  true,
+			MinInstSize: 4,
+			MaxInstSize: 4,
+			NOP:         b,
+		}
+	}
+	var info *ArchMetadata
+	switch arch {
+	case ARCH_X86:
+		var ptrSize int
+		switch mode {
+		case MODE_16:
+			ptrSize = 2
+		case MODE_32:
+			ptrSize = 4
+		case MODE_64:
+			ptrSize = 8
+		}
+		info = &ArchMetadata{
+			PointerSize: ptrSize,
+			ByteOrder:   order,
+			Alignment:   1,
+			MinInstSize: 1,
+			MaxInstSize: 15,
+			NOP:         []byte{0x90},
+		}
+	case ARCH_ARM:
+		if mode&MODE_THUMB != 0 {
+			nop := make([]byte, 2)
+			order.PutUint16(nop, 0xBF00)
+			info = &ArchMetadata{
+				PointerSize: 4,
+				ByteOrder:   order,
+				Alignment:   2,
+				MinInstSize: 2,
+				MaxInstSize: 4,
+				NOP:         nop,
+			}
+		} else {
+			info = fixed(4, 0xE320F000)
+		}
+	case ARCH_ARM64:
+		info = fixed(8, 0xD503201F)
+	case ARCH_MIPS:
+		if mode&MODE_MIPS64 != 0 {
+			info = fixed(8, 0x00000000)
+		} else {
+			info = fixed(4, 0x00000000)
+		}
+	case ARCH_PPC:
+		if mode&MODE_PPC64 != 0 {
+			info = fixed(8, 0x60000000)
+		} else {
+			info = fixed(4, 0x60000000)
+		}
+	case ARCH_SPARC:
+		if mode&MODE_SPARC64 != 0 {
+			info = fixed(8, 0x01000000)
+		} else {
+			info = fixed(4, 0x01000000)
+		}
+	case ARCH_SYSTEMZ:
+		info = &ArchMetadata{
+			PointerSize: 8,
+			ByteOrder:   order,
+			Alignment:   2,
+			MinInstSize: 2,
+			MaxInstSize: 6,
+			NOP:         []byte{0x47, 0x00, 0x00, 0x00},
+		}
+	case ARCH_HEXAGON:
+		// the instructions of hexagon are always little-endian
+		order = binary.LittleEndian
+		info = fixed(4, 0x7F00C000)
+	case ARCH_EVM:
+		info = &ArchMetadata{
+			PointerSize: 32,
+			ByteOrder:   binary.BigEndian,
+			Alignment:   1,
+			MinInstSize: 1,
+			MaxInstSize: 33,
+		}
+	case ARCH_RISCV:
+		if mode&MODE_RISCV64 != 0 {
+			info = fixed(8, 0x00000013)
+		} else {
+			info = fixed(4, 0x00000013)
+		}
+	}
+	return info, nil
+}
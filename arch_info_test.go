@@ -0,0 +1,65 @@
+package keystone
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchInfo(t *testing.T) {
+	t.Run("nop", func(t *testing.T) {
+		for _, target := range SupportedTargets() {
+			info, err := ArchInfo(target.Arch, target.Mode)
+			require.NoError(t, err)
+			if info.NOP == nil {
+				continue
+			}
+
+			engine, err := NewEngine(target.Arch, target.Mode)
+			require.NoError(t, err)
+
+			inst, err := engine.Assemble("nop", 0)
+			require.NoError(t, err, "%s %s", target.Arch, target.Mode)
+			require.Equal(t, info.NOP, inst, "%s %s", target.Arch, target.Mode)
+			require.GreaterOrEqual(t, len(inst), info.MinInstSize)
+			require.LessOrEqual(t, len(inst), info.MaxInstSize)
+			require.Zero(t, len(inst)%info.Alignment)
+
+			err = engine.Close()
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("common", func(t *testing.T) {
+		info, err := ArchInfo(ARCH_X86, MODE_64)
+		require.NoError(t, err)
+		expected := &ArchMetadata{
+			PointerSize: 8,
+			ByteOrder:   binary.LittleEndian,
+			Alignment:   1,
+			FixedWidth:  false,
+			MinInstSize: 1,
+			MaxInstSize: 15,
+			NOP:         []byte{0x90},
+		}
+		require.Equal(t, expected, info)
+
+		info, err = ArchInfo(ARCH_MIPS, MODE_MIPS32|MODE_BIG_ENDIAN)
+		require.NoError(t, err)
+		require.Equal(t, 4, info.PointerSize)
+		require.Equal(t, binary.BigEndian, info.ByteOrder)
+		require.True(t, info.FixedWidth)
+
+		info, err = ArchInfo(ARCH_ARM, MODE_THUMB|MODE_BIG_ENDIAN)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xBF, 0x00}, info.NOP)
+		require.False(t, info.FixedWidth)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		info, err := ArchInfo(ARCH_X86, MODE_THUMB)
+		require.ErrorIs(t, err, ErrMode)
+		require.Nil(t, info)
+	})
+}
@@ -0,0 +1,49 @@
+package keystone
+
+// minSourceSize is the minimum capacity of the source buffer in arena.
+const minSourceSize = 4 * 1024
+
+// arena is the scratch memory in wasm module that reused by each call, it
+// is allocated when open keystone and discarded with the module instance.
+type arena struct {
+	// cells is used to store the three uint32 output
+	// values of ks_asm, and the handle of ks_open.
+	cells uint32
+
+	// src is the source buffer, it grows if the source is larger than it.
+	src    uint32
+	srcCap uint32
+}
+
+// cell returns the address of the i-th output cell.
+func (a *arena) cell(i int) uint32 {
+	return a.cells + uint32(i)*4
+}
+
+// writeSource is used to write the source code with a NUL terminator to the
+// source buffer, the buffer is reallocated if the capacity is not enough.
+func (e *Engine) writeSource(src string) (uint32, error) {
+	size := uint32(len(src)) + 1
+	if size > e.arena.srcCap {
+		capacity := max(e.arena.srcCap, minSourceSize)
+		for capacity < size && capacity < 1<<31 {
+			capacity *= 2
+		}
+		capacity = max(capacity, size)
+		if e.arena.src != 0 {
+			err := e.free(e.arena.src)
+			if err != nil {
+				return 0, err
+			}
+			e.arena.src, e.arena.srcCap = 0, 0
+		}
+		ptr, err := e.malloc(capacity)
+		if err != nil {
+			return 0, err
+		}
+		e.arena.src, e.arena.srcCap = ptr, capacity
+	}
+	e.memory.WriteString(e.arena.src, src)
+	e.memory.WriteByte(e.arena.src+size-1, 0)
+	return e.arena.src, nil
+}
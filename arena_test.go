@@ -0,0 +1,54 @@
+package keystone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Arena(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	require.NotZero(t, engine.arena.cells)
+	require.Zero(t, engine.arena.src)
+
+	t.Run("reuse", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			inst, err := engine.Assemble("nop", 0)
+			require.NoError(t, err)
+			require.Equal(t, []byte{0xC3}, inst)
+		}
+		require.Equal(t, uint32(minSourceSize), engine.arena.srcCap)
+		require.Equal(t, uint64(2), engine.Stats().Calls["malloc"])
+	})
+
+	t.Run("grow", func(t *testing.T) {
+		src := "n" + strings.Repeat(" ", 3*minSourceSize)
+		inst, err := engine.Assemble(src, 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xC3}, inst)
+		require.Equal(t, uint32(4*minSourceSize), engine.arena.srcCap)
+
+		stats := engine.Stats()
+		require.Equal(t, 2, stats.LiveAllocs)
+		require.Equal(t, uint64(1), stats.Calls["free"])
+
+		// the terminator is written after the source
+		b, ok := engine.memory.ReadByte(engine.arena.src + uint32(len(src)))
+		require.True(t, ok)
+		require.Zero(t, b)
+	})
+
+	t.Run("after recover", func(t *testing.T) {
+		_, err := engine.Assemble("unreachable", 0)
+		require.Error(t, err)
+		inst, err := engine.Assemble("nop", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xC3}, inst)
+		require.Equal(t, uint32(minSourceSize), engine.arena.srcCap)
+		require.Equal(t, 2, engine.Stats().LiveAllocs)
+	})
+}
@@ -0,0 +1,129 @@
+package keystone
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AssembleAll is used to assemble input source code and report every error
+// in it, not just the first. The statement that caused error is replaced with
+// a placeholder and the rest of source code is assembled again. If a symbol is
+// missing, it is resolved to the base address as placeholder, so the size of
+// statements that reference it are kept. For the arch that all instructions
+// have the same size, the invalid instruction is replaced with nop, so the
+// addresses of the following statements are kept, otherwise the statement is
+// removed and the following statements are moved. The result contains the
+// output of the statements that were assembled successfully, the diagnostics
+// are sorted by line, and the error is only returned when the failure is not
+// caused by source code.
+func (e *Engine) AssembleAll(src string, addr uint64) (*AssembleResult, []*KeystoneError, error) {
+	lines := splitLines(src)
+	missing := make(map[string]bool)
+	var asked []string
+	prev := e.resolver
+	err := e.SetSymbolResolver(func(symbol string) (uint64, bool) {
+		if prev != nil {
+			if val, ok := prev(symbol); ok {
+				return val, true
+			}
+		}
+		if missing[symbol] {
+			return addr, true
+		}
+		asked = append(asked, symbol)
+		return 0, false
+	})
+	if err == nil {
+		defer func() { _ = e.SetSymbolResolver(prev) }()
+	}
+	filler := e.filler()
+	var diags []*KeystoneError
+	reported := make(map[int]bool)
+	replaced := make(map[int]bool)
+	for {
+		src = strings.Join(lines, "\n")
+		asked = nil
+		result, err := e.assemble(e.context, src, addr)
+		if err == nil {
+			return result, sortDiags(diags), nil
+		}
+		// the error is not caused by source code, like failed to recover engine
+		ke := asmError(result, err)
+		if ke == nil {
+			return nil, sortDiags(diags), err
+		}
+		names := asked
+		e.locate(e.context, src, addr, result.StatCount, ke)
+		if ke.Line == 0 {
+			diags = append(diags, ke)
+			return result, sortDiags(diags), nil
+		}
+		idx := ke.Line - 1
+		if !reported[idx] {
+			reported[idx] = true
+			diags = append(diags, ke)
+			// resolve the missing symbols with placeholder
+			if ke.Code == ERR_ASM_SYMBOL_MISSING && len(names) > 0 {
+				for _, name := range names {
+					missing[name] = true
+				}
+				continue
+			}
+		}
+		// the line can not be fixed, replace it with placeholder
+		// and remove the placeholder if it is also failed
+		if replaced[idx] {
+			if lines[idx] == "" {
+				return result, sortDiags(diags), nil
+			}
+			lines[idx] = ""
+			continue
+		}
+		replaced[idx] = true
+		lines[idx] = placeholder(lines[idx], filler)
+	}
+}
+
+// filler returns the directive that outputs a nop for replace the invalid
+// instruction, it is empty if the size of instructions are not the same.
+func (e *Engine) filler() string {
+	info, err := ArchInfo(e.arch, e.mode)
+	if err != nil || !info.FixedWidth || len(info.NOP) == 0 {
+		return ""
+	}
+	bytes := make([]string, len(info.NOP))
+	for i, b := range info.NOP {
+		bytes[i] = fmt.Sprintf("0x%02X", b)
+	}
+	return ".byte " + strings.Join(bytes, ", ")
+}
+
+// placeholder is used to replace the statement in line with the filler, the
+// label before it is kept, the directive is removed without filler.
+func placeholder(line, filler string) string {
+	var label string
+	if m := labelRe.FindStringIndex(line); m != nil {
+		label = line[:m[1]]
+	}
+	if strings.HasPrefix(statement(line), ".") {
+		return label
+	}
+	if label == "" {
+		return filler
+	}
+	return label + " " + filler
+}
+
+// sortDiags is used to sort the diagnostics by line, the
+// diagnostics that the location is unknown are at the end.
+func sortDiags(diags []*KeystoneError) []*KeystoneError {
+	sort.SliceStable(diags, func(i, j int) bool {
+		li, lj := diags[i].Line, diags[j].Line
+		if li == 0 || lj == 0 {
+			return li != 0 && lj == 0
+		}
+		return li < lj
+	})
+	return diags
+}
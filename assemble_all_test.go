@@ -0,0 +1,120 @@
+package keystone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_AssembleAll(t *testing.T) {
+	engine, err := NewEngine(ARCH_X86, MODE_32)
+	require.NoError(t, err)
+
+	t.Run("common", func(t *testing.T) {
+		src := "xor eax, eax\nret\n"
+		result, diags, err := engine.AssembleAll(src, 0)
+		require.NoError(t, err)
+		require.Empty(t, diags)
+		require.Equal(t, []byte{0x31, 0xC0, 0xC3}, result.Inst)
+	})
+
+	t.Run("multi errors", func(t *testing.T) {
+		src := "xor eax, eax\n"
+		src += "invalid1\n"
+		src += "inc eax\n"
+		src += "jmp missing\n"
+		src += "invalid2 eax\n"
+		src += "ret\n"
+		result, diags, err := engine.AssembleAll(src, 0)
+		require.NoError(t, err)
+		require.Len(t, diags, 3)
+
+		require.ErrorIs(t, diags[0], ErrMnemonicFail)
+		require.Equal(t, 2, diags[0].Line)
+		require.Equal(t, "invalid1", diags[0].Text)
+
+		require.ErrorIs(t, diags[1], ErrSymbolMissing)
+		require.Equal(t, 4, diags[1].Line)
+		require.Equal(t, "jmp missing", diags[1].Text)
+
+		require.ErrorIs(t, diags[2], ErrMnemonicFail)
+		require.Equal(t, 5, diags[2].Line)
+		require.Equal(t, "invalid2 eax", diags[2].Text)
+
+		// the missing symbol is resolved to the base address
+		expected := []byte{0x31, 0xC0, 0x40, 0xEB, 0xFB, 0xC3}
+		require.Equal(t, expected, result.Inst)
+	})
+
+	t.Run("resolver is restored", func(t *testing.T) {
+		_, err := engine.Assemble("jmp missing\n", 0)
+		require.ErrorIs(t, err, ErrSymbolMissing)
+	})
+
+	err = engine.Close()
+	require.NoError(t, err)
+}
+
+func TestEngine_AssembleAllPlaceholder(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	t.Run("removed", func(t *testing.T) {
+		result, diags, err := engine.AssembleAll("n\nx\nn\nx", 0)
+		require.NoError(t, err)
+		require.Len(t, diags, 2)
+		require.Equal(t, 2, diags[0].Line)
+		require.Equal(t, 4, diags[1].Line)
+		require.Equal(t, []byte{0xC3, 0xC3}, result.Inst)
+	})
+
+	t.Run("filler", func(t *testing.T) {
+		require.Empty(t, engine.filler())
+
+		arch, mode := engine.arch, engine.mode
+		engine.arch, engine.mode = ARCH_ARM64, MODE_LITTLE_ENDIAN
+		defer func() { engine.arch, engine.mode = arch, mode }()
+		require.Equal(t, ".byte 0x1F, 0x20, 0x03, 0xD5", engine.filler())
+	})
+
+	for _, item := range []struct {
+		line   string
+		filler string
+		expect string
+	}{
+		{"invalid", "", ""},
+		{"invalid", ".byte 0x00", ".byte 0x00"},
+		{"start: invalid", ".byte 0x00", "start: .byte 0x00"},
+		{"start: .invalid", ".byte 0x00", "start:"},
+		{"  .invalid 1", ".byte 0x00", ""},
+	} {
+		require.Equal(t, item.expect, placeholder(item.line, item.filler), item.line)
+	}
+}
+
+func TestSortDiags(t *testing.T) {
+	diags := []*KeystoneError{{Line: 5}, {Line: 0}, {Line: 2}, {Line: 3}}
+	diags = sortDiags(diags)
+	var lines []int
+	for _, diag := range diags {
+		lines = append(lines, diag.Line)
+	}
+	require.Equal(t, []int{2, 3, 5, 0}, lines)
+}
+
+func TestEngine_AssembleAllRecoverFailed(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	_, err = engine.Assemble("throw", 0)
+	require.Error(t, err)
+	// the unknown option will fail after instantiate again
+	engine.options = append(engine.options, option{typ: 3})
+
+	result, diags, err := engine.AssembleAll("n\nx\nn", 0)
+	require.ErrorContains(t, err, "failed to recover engine")
+	require.Nil(t, result)
+	require.Empty(t, diags)
+}
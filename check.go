@@ -0,0 +1,109 @@
+package keystone
+
+import (
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+// releaseFuncMap returns the names of functions in func_map.go, they
+// are only valid for the release wasm module that embedded by default.
+func releaseFuncMap() *wasm.FuncMap {
+	fm := wasm.FuncMap{
+		ImportModule: importModule,
+		Imports: map[string]string{
+			"__cxa_throw":            ___cxa_throw,
+			"__syscall_fstat64":      ___syscall_fstat64,
+			"__syscall_getcwd":       ___syscall_getcwd,
+			"__syscall_lstat64":      ___syscall_lstat64,
+			"__syscall_newfstatat":   ___syscall_newfstatat,
+			"__syscall_openat":       ___syscall_openat,
+			"__syscall_stat64":       ___syscall_stat64,
+			"_abort_js":              __abort_js,
+			"_mmap_js":               __mmap_js,
+			"_munmap_js":             __munmap_js,
+			"emscripten_resize_heap": _emscripten_resize_heap,
+			"environ_get":            _environ_get,
+			"environ_sizes_get":      _environ_sizes_get,
+			"exit":                   _exit,
+			"fd_close":               _fd_close,
+			"fd_fdstat_get":          _fd_fdstat_get,
+			"fd_pread":               _fd_pread,
+			"fd_read":                _fd_read,
+			"fd_seek":                _fd_seek,
+			"fd_write":               _fd_write,
+		},
+		Exports: map[string]string{
+			"malloc":            _malloc,
+			"free":              _free,
+			"ks_open":           _ks_open,
+			"ks_option":         _ks_option,
+			"ks_asm":            _ks_asm,
+			"ks_free":           _ks_free,
+			"ks_close":          _ks_close,
+			"ks_errno":          _ks_errno,
+			"ks_strerror":       _ks_strerror,
+			"ks_version":        _ks_version,
+			"ks_arch_supported": _ks_arch_supported,
+		},
+	}
+	// remove the functions that not exported
+	for name, export := range fm.Exports {
+		if export == "" {
+			delete(fm.Exports, name)
+		}
+	}
+	return &fm
+}
+
+// checkModule is used to check the compiled module has the expected imported
+// and exported functions, if the function names are not match the wasm module,
+// it will return a clear error instead of a nil function when call it.
+func checkModule(mod wazero.CompiledModule, fm *wasm.FuncMap) error {
+	imports := make(map[string]wasm.Func, len(wasm.Imports))
+	for _, fn := range wasm.Imports {
+		imports[fm.Imports[fn.Name]] = fn
+	}
+	for _, def := range mod.ImportedFunctions() {
+		module, name, _ := def.Import()
+		fn, ok := imports[name]
+		if module != fm.ImportModule || !ok {
+			return fmt.Errorf("wasm module imports unknown function %s.%s", module, name)
+		}
+		err := checkSignature(def, fn, name)
+		if err != nil {
+			return err
+		}
+	}
+	exports := mod.ExportedFunctions()
+	for _, fn := range wasm.Exports {
+		name, ok := fm.Exports[fn.Name]
+		if !ok && fn.Optional {
+			continue
+		}
+		def, ok := exports[name]
+		if !ok {
+			return fmt.Errorf("wasm module not export %s as %q", fn.Name, name)
+		}
+		err := checkSignature(def, fn, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSignature(def api.FunctionDefinition, fn wasm.Func, name string) error {
+	typ := wasm.FuncType{
+		Params:  def.ParamTypes(),
+		Results: def.ResultTypes(),
+	}
+	if !typ.Equal(fn.Type) {
+		return fmt.Errorf("function %s (%q) in wasm module has signature %s, expected %s",
+			fn.Name, name, typ, fn.Type)
+	}
+	return nil
+}
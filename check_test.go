@@ -0,0 +1,48 @@
+package keystone
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+func TestCheckModule(t *testing.T) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer func() { _ = runtime.Close(ctx) }()
+
+	t.Run("missing export", func(t *testing.T) {
+		mod, err := runtime.CompileModule(ctx, wasm.Magic)
+		require.NoError(t, err)
+		err = checkModule(mod, releaseFuncMap())
+		require.EqualError(t, err, `wasm module not export malloc as "F"`)
+	})
+
+	t.Run("wrong signature", func(t *testing.T) {
+		// (func (export "F") (param i64) (result i32) i32.const 0)
+		bin := append([]byte{}, wasm.Magic...)
+		bin = append(bin, 0x01, 0x06, 0x01, 0x60, 0x01, 0x7E, 0x01, 0x7F)
+		bin = append(bin, 0x03, 0x02, 0x01, 0x00)
+		bin = append(bin, 0x07, 0x05, 0x01, 0x01, 'F', 0x00, 0x00)
+		bin = append(bin, 0x0A, 0x06, 0x01, 0x04, 0x00, 0x41, 0x00, 0x0B)
+		mod, err := runtime.CompileModule(ctx, bin)
+		require.NoError(t, err)
+		err = checkModule(mod, releaseFuncMap())
+		require.EqualError(t, err, `function malloc ("F") in wasm module has signature (i64) -> (i32), expected (i32) -> (i32)`)
+	})
+
+	t.Run("unknown import", func(t *testing.T) {
+		// (import "env" "foo" (func))
+		bin := append([]byte{}, wasm.Magic...)
+		bin = append(bin, 0x01, 0x04, 0x01, 0x60, 0x00, 0x00)
+		bin = append(bin, 0x02, 0x0B, 0x01, 0x03, 'e', 'n', 'v', 0x03, 'f', 'o', 'o', 0x00, 0x00)
+		mod, err := runtime.CompileModule(ctx, bin)
+		require.NoError(t, err)
+		err = checkModule(mod, releaseFuncMap())
+		require.EqualError(t, err, "wasm module imports unknown function env.foo")
+	})
+}
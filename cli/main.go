@@ -1,51 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/moloch--/go-keystone"
 	"github.com/spf13/cobra"
 )
 
 var (
-	archS   string
-	modeS   string
-	syntaxS string
+	arch    = keystone.ARCH_X86
+	mode    = keystone.MODE_32
+	syntax  = keystone.OPT_SYNTAX_INTEL
+	target  keystone.Target
 	address uint64
 	srcPath string
 	output  string
+	listing bool
+	keepGo  bool
+	wasm    string
 )
 
-const supportedOptions = `
-  +---------+----------+---------+
-  |  arch   |   mode   | syntax  |
-  +---------+----------+---------+
-  | arm     | le       | intel   |
-  | arm64   | be       | att     |
-  | mips    | arm      | nasm    |
-  | x86     | thumb    | masm    |
-  | ppc     | v8       | gas     |
-  | sparc   | micro    | radix16 |
-  | systemz | mips3    |         |
-  | hexagon | mips32r6 |         |
-  | evm     | mips32   |         |
-  | riscv   | mips64   |         |
-  | max     | 16       |         |
-  |         | 32       |         |
-  |         | 64       |         |
-  |         | ppc32    |         |
-  |         | ppc64    |         |
-  |         | qpx      |         |
-  |         | riscv32  |         |
-  |         | riscv64  |         |
-  |         | sparc32  |         |
-  |         | sparc64  |         |
-  |         | v9       |         |
-  +---------+----------+---------+
-
-`
+// supportedOptions is used to generate the table of supported
+// arch and mode combinations and the syntax keywords.
+func supportedOptions() string {
+	targets := keystone.SupportedTargets()
+	archW, modeW := len("arch"), len("mode")
+	for _, target := range targets {
+		archW = max(archW, len(target.Arch.String()))
+		modeW = max(modeW, len(target.Mode.String()))
+	}
+	sep := fmt.Sprintf("  +-%s-+-%s-+\n", strings.Repeat("-", archW), strings.Repeat("-", modeW))
+	buf := strings.Builder{}
+	buf.WriteString("\n")
+	buf.WriteString(sep)
+	fmt.Fprintf(&buf, "  | %-*s | %-*s |\n", archW, "arch", modeW, "mode")
+	buf.WriteString(sep)
+	for _, target := range targets {
+		fmt.Fprintf(&buf, "  | %-*s | %-*s |\n", archW, target.Arch, modeW, target.Mode)
+	}
+	buf.WriteString(sep)
+	fmt.Fprintf(&buf, "\n  syntax: %s\n\n", strings.Join(keystone.SyntaxOptions(), ", "))
+	return buf.String()
+}
 
 func main() {
 	rootCmd := newRootCmd()
@@ -59,30 +59,49 @@ func newRootCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "go-keystone",
 		Short: "Assemble source files with the Keystone engine",
-		Long:  "Assemble source files with the Keystone engine." + supportedOptions,
+		Long:  "Assemble source files with the Keystone engine." + supportedOptions(),
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			cmd.SilenceUsage = true
 			if srcPath == "" {
 				return errors.New("the --src flag must be specified")
 			}
+			if cmd.Flags().Changed("target") {
+				arch, mode = target.Arch, target.Mode
+				if target.Syntax != 0 && !cmd.Flags().Changed("syntax") {
+					syntax = target.Syntax
+				}
+			}
 			return assemble()
 		},
 	}
 
-	cmd.Flags().StringVar(&archS, "arch", "x86", "set the target architecture")
-	cmd.Flags().StringVar(&modeS, "mode", "32", "set the target mode")
-	cmd.Flags().StringVar(&syntaxS, "syntax", "intel", "set the assembly syntax")
+	cmd.Flags().Var(&arch, "arch", "set the target architecture")
+	cmd.Flags().Var(&mode, "mode", "set the target mode, keywords can be combined like arm+be")
+	cmd.Flags().Var(&syntax, "syntax", "set the assembly syntax")
+	cmd.Flags().Var(&target, "target", "set the target triple like x86_64 or armv7-thumb, it overrides --arch and --mode")
 	cmd.Flags().Uint64Var(&address, "addr", 0, "set the base address")
 	cmd.Flags().StringVar(&srcPath, "src", "", "set the source file path or inline assembly content")
 	cmd.Flags().StringVar(&output, "out", "", "set the output file path (stdout if omitted)")
+	cmd.Flags().BoolVar(&listing, "listing", false, "print the listing with address and machine code of each line")
+	cmd.Flags().BoolVar(&keepGo, "keep-going", false, "report every error in source instead of stopping at the first")
+	cmd.Flags().StringVar(&wasm, "wasm", "", "set the keystone wasm module path instead of the embedded module (or "+keystone.ModuleEnv+")")
 
 	if err := cmd.RegisterFlagCompletionFunc("arch", func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
 		return keystone.ArchOptions(), cobra.ShellCompDirectiveNoFileComp
 	}); err != nil {
 		panic(err)
 	}
-	if err := cmd.RegisterFlagCompletionFunc("mode", func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
-		return keystone.ModeOptions(), cobra.ShellCompDirectiveNoFileComp
+	if err := cmd.RegisterFlagCompletionFunc("mode", func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		// complete the next keyword of combined mode like "arm+be"
+		idx := strings.LastIndex(toComplete, "+")
+		if idx == -1 {
+			return keystone.ModeOptions(), cobra.ShellCompDirectiveNoFileComp
+		}
+		var options []string
+		for _, option := range keystone.ModeOptions() {
+			options = append(options, toComplete[:idx+1]+option)
+		}
+		return options, cobra.ShellCompDirectiveNoFileComp
 	}); err != nil {
 		panic(err)
 	}
@@ -123,11 +142,7 @@ func newRootCmd() *cobra.Command {
 }
 
 func assemble() error {
-	arch := keystone.StringToArch(archS)
-	mode := keystone.StringToMode(modeS)
-	syntax := keystone.StringToSyntax(syntaxS)
-
-	engine, err := keystone.NewEngine(arch, mode)
+	engine, err := newEngine()
 	if err != nil {
 		return err
 	}
@@ -138,18 +153,43 @@ func assemble() error {
 	}
 
 	var src []byte
+	srcName := "<inline>"
 	if info, err := os.Stat(srcPath); err == nil && !info.IsDir() {
 		src, err = os.ReadFile(srcPath)
 		if err != nil {
 			return err
 		}
+		srcName = srcPath
 	} else {
 		src = []byte(srcPath)
 	}
 
-	inst, err := engine.Assemble(string(src), address)
-	if err != nil {
-		return err
+	if listing {
+		return diagnostic(srcName, printListing(engine, string(src)))
+	}
+
+	var inst []byte
+	if keepGo {
+		result, diags, err := engine.AssembleAll(string(src), address)
+		if err != nil {
+			return err
+		}
+		for _, diag := range diags {
+			fmt.Fprintln(os.Stderr, diagnostic(srcName, diag))
+		}
+		if len(diags) != 0 {
+			return fmt.Errorf("%d error(s) generated", len(diags))
+		}
+		inst = result.Inst
+	} else {
+		result, err := engine.AssembleResult(string(src), address)
+		if err != nil {
+			return diagnostic(srcName, err)
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+		inst = result.Inst
 	}
 
 	if output == "" {
@@ -159,3 +199,57 @@ func assemble() error {
 
 	return os.WriteFile(output, inst, 0644)
 }
+
+// newEngine is used to create engine with the wasm module in
+// --wasm flag, or the default module if it is not set.
+func newEngine() (*keystone.Engine, error) {
+	if wasm == "" {
+		return keystone.NewEngine(arch, mode)
+	}
+	bin, err := os.ReadFile(wasm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm module: %s", err)
+	}
+	return keystone.NewEngineWithModule(bin, arch, mode)
+}
+
+func printListing(engine *keystone.Engine, src string) error {
+	lines, err := engine.Listing(src, address)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.Buffer{}
+	for _, line := range lines {
+		inst := make([]string, len(line.Inst))
+		for i, b := range line.Inst {
+			inst[i] = fmt.Sprintf("%02X", b)
+		}
+		fmt.Fprintf(&buf, "%016X  %-30s  %5d  %s\n",
+			line.Address, strings.Join(inst, " "), line.Line, line.Text,
+		)
+	}
+
+	if output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	return os.WriteFile(output, buf.Bytes(), 0644)
+}
+
+// diagnostic is used to convert the keystone error to compiler-style
+// diagnostic like "file:line:col: error: message".
+func diagnostic(name string, err error) error {
+	var ke *keystone.KeystoneError
+	if !errors.As(err, &ke) || ke.Line == 0 {
+		return err
+	}
+	msg := fmt.Sprintf("%s:%d:%d: error: %s\n    %s",
+		name, ke.Line, ke.Column, ke.Message, ke.Text,
+	)
+	for _, line := range ke.Warnings {
+		msg += "\n" + line
+	}
+	return errors.New(msg)
+}
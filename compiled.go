@@ -0,0 +1,151 @@
+package keystone
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+// EngineConfig contains the options about the wasm runtime of engine.
+type EngineConfig struct {
+	// Compiler is used to select the optimizing compiler of wazero, it is
+	// faster for large assemblies but will generate RWX memory. If the
+	// platform is not supported, it will fall back to the interpreter.
+	Compiler bool
+
+	// CacheDir is the directory for store the compiled module,
+	// it is only used with Compiler.
+	CacheDir string
+}
+
+// compiled contains the wasm runtime and the compiled keystone module,
+// it is shared by all engines, so NewEngine only need to instantiate it.
+type compiled struct {
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+
+	// funcs contains the names of functions in wasm module,
+	// table is the export name of function table.
+	funcs *wasm.FuncMap
+	table string
+
+	// refs is the number of engines that use it, the runtime is closed when
+	// it is zero, except the pinned that is the default module of NewEngine.
+	// They are protected by compiledMu.
+	key    compiledKey
+	refs   int
+	pinned bool
+}
+
+// compiledKey is the key of shared compiled module.
+type compiledKey struct {
+	config EngineConfig
+	hash   [sha256.Size]byte
+}
+
+var (
+	compiledMu sync.Mutex
+	compiledM  = make(map[compiledKey]*compiled)
+
+	// engineID is used to generate unique module instance names
+	// in the shared wasm runtime.
+	engineID atomic.Uint64
+)
+
+// loadCompiled is used to get the shared compiled module with the config, it
+// will be compiled when first call. The caller must call release after use,
+// if pin is true, the compiled module is kept after all engines are closed.
+func loadCompiled(config EngineConfig, m *wasmModule, pin bool) (*compiled, error) {
+	if !config.Compiler {
+		config.CacheDir = ""
+	}
+	key := compiledKey{config: config, hash: m.hash}
+	compiledMu.Lock()
+	defer compiledMu.Unlock()
+	c, ok := compiledM[key]
+	if !ok {
+		var err error
+		c, err = compileModule(config, m)
+		if err != nil {
+			return nil, err
+		}
+		c.key = key
+		compiledM[key] = c
+	}
+	c.refs++
+	c.pinned = c.pinned || pin
+	return c, nil
+}
+
+// release is used to decrease the reference count, the wasm runtime
+// is closed when the last engine is closed if it is not pinned.
+func (c *compiled) release() error {
+	compiledMu.Lock()
+	defer compiledMu.Unlock()
+	c.refs--
+	if c.refs > 0 || c.pinned {
+		return nil
+	}
+	delete(compiledM, c.key)
+	return c.close()
+}
+
+// compileModule is used to create a wasm runtime and compile the keystone module.
+func compileModule(config EngineConfig, m *wasmModule) (*compiled, error) {
+	// the compiled module is shared, so not use the context from caller
+	ctx := context.Background()
+	var rc wazero.RuntimeConfig
+	if config.Compiler {
+		rc = wazero.NewRuntimeConfig()
+		if config.CacheDir != "" {
+			cache, err := wazero.NewCompilationCacheWithDir(config.CacheDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create compilation cache: %s", err)
+			}
+			rc = rc.WithCompilationCache(cache)
+		}
+	} else {
+		// prevent generate RWX memory
+		rc = wazero.NewRuntimeConfigInterpreter()
+	}
+	rc = rc.WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, rc)
+	// if failed to compile module, close the wasm runtime
+	var ok bool
+	defer func() {
+		if !ok {
+			_ = runtime.Close(ctx)
+		}
+	}()
+	mod, err := runtime.CompileModule(ctx, m.bin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile wasm module: %s", err)
+	}
+	funcs, table, err := m.funcMap(mod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystone wasm module: %s", err)
+	}
+	err = processImport(ctx, runtime, funcs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process wasm module import: %s", err)
+	}
+	ok = true
+	c := compiled{
+		runtime: runtime,
+		module:  mod,
+		funcs:   funcs,
+		table:   table,
+	}
+	return &c, nil
+}
+
+// close is used to close the wasm runtime and all module instances in it.
+func (c *compiled) close() error {
+	return c.runtime.Close(context.Background())
+}
@@ -1,9 +1,18 @@
 package keystone
 
-type Arch = uint
-type Mode = uint
-type OptionType = uint
-type OptionValue = uint
+// Arch is the architecture type of keystone.
+type Arch uint
+
+// Mode is the mode type of keystone, it is a bitmask.
+type Mode uint
+
+// OptionType is the type of option for Engine.Option.
+type OptionType uint
+
+// OptionValue is the value of option for Engine.Option.
+type OptionValue uint
+
+// Error is the error code of keystone.
 type Error = uint32
 
 const (
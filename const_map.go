@@ -1,7 +1,9 @@
 package keystone
 
 import (
+	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -43,6 +45,26 @@ var modeM = map[string]Mode{
 	"v9":       MODE_V9,
 }
 
+// modeGroupM contains the groups of mode keywords, the keywords
+// in the same group with different value can not be combined.
+var modeGroupM = map[string]string{
+	"le":      "endian",
+	"be":      "endian",
+	"arm":     "isa",
+	"thumb":   "isa",
+	"16":      "width",
+	"32":      "width",
+	"64":      "width",
+	"mips32":  "width",
+	"mips64":  "width",
+	"ppc32":   "width",
+	"ppc64":   "width",
+	"riscv32": "width",
+	"riscv64": "width",
+	"sparc32": "width",
+	"sparc64": "width",
+}
+
 var syntaxM = map[string]OptionValue{
 	"intel":   OPT_SYNTAX_INTEL,
 	"att":     OPT_SYNTAX_ATT,
@@ -57,9 +79,36 @@ func StringToArch(arch string) Arch {
 	return archM[strings.ToLower(arch)]
 }
 
-// StringToMode is used to convert string to mode.
+// StringToMode is used to convert string to mode, the keywords can be
+// joined with "+", like "mips64+be", it returns 0 if the mode is invalid.
 func StringToMode(mode string) Mode {
-	return modeM[strings.ToLower(mode)]
+	m, err := ParseMode(mode)
+	if err != nil {
+		return 0
+	}
+	return m
+}
+
+// ParseArch is like StringToArch but return error if the arch is unknown,
+// the keyword "max" is rejected because it is not a real architecture.
+func ParseArch(arch string) (Arch, error) {
+	var a Arch
+	err := a.UnmarshalText([]byte(arch))
+	if err != nil {
+		return 0, err
+	}
+	return a, nil
+}
+
+// ParseMode is like StringToMode but return error if the mode is unknown
+// or the keywords are contradictory, like "le+be" and "arm+thumb".
+func ParseMode(mode string) (Mode, error) {
+	var m Mode
+	err := m.UnmarshalText([]byte(mode))
+	if err != nil {
+		return 0, err
+	}
+	return m, nil
 }
 
 // StringToSyntax is used to convert string to syntax.
@@ -70,8 +119,10 @@ func StringToSyntax(syntax string) OptionValue {
 // ArchOptions returns the list of supported architecture keywords.
 func ArchOptions() []string {
 	keys := make([]string, 0, len(archM))
-	for key := range archM {
-		keys = append(keys, key)
+	for key, arch := range archM {
+		if arch.valid() {
+			keys = append(keys, key)
+		}
 	}
 	sort.Strings(keys)
 	return keys
@@ -96,3 +147,227 @@ func SyntaxOptions() []string {
 	sort.Strings(keys)
 	return keys
 }
+
+var optionM = map[string]OptionType{
+	"syntax":       OPT_SYNTAX,
+	"sym_resolver": OPT_SYM_RESOLVER,
+}
+
+// the bits of mode in the order for String.
+var modeBits = []struct {
+	mode Mode
+	name string
+}{
+	{MODE_ARM, "arm"},
+	{MODE_16, "16"},
+	{MODE_32, "32"},
+	{MODE_64, "64"},
+	{MODE_THUMB, "thumb"},
+	{MODE_MIPS3, "mips3"},
+	{MODE_V8, "v8"},
+	{MODE_BIG_ENDIAN, "be"},
+}
+
+// modeMask contains all the known bits of mode.
+var modeMask = func() Mode {
+	var mask Mode
+	for _, bit := range modeBits {
+		mask |= bit.mode
+	}
+	return mask
+}()
+
+// keyOf is used to find the keyword of value in the map.
+func keyOf[T comparable](m map[string]T, val T) (string, bool) {
+	keys := make([]string, 0, 1)
+	for key, v := range m {
+		if v == val {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	return keys[0], true
+}
+
+func (a Arch) String() string {
+	if key, ok := keyOf(archM, a); ok {
+		return key
+	}
+	return fmt.Sprintf("Arch(%d)", uint(a))
+}
+
+// valid is used to check the arch can be used to open keystone engine.
+func (a Arch) valid() bool {
+	return a >= ARCH_ARM && a < ARCH_MAX
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (a Arch) MarshalText() ([]byte, error) {
+	if !a.valid() {
+		return nil, fmt.Errorf("invalid arch %d", uint(a))
+	}
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *Arch) UnmarshalText(text []byte) error {
+	arch, ok := archM[strings.ToLower(string(text))]
+	if !ok || !arch.valid() {
+		return fmt.Errorf("invalid arch %q", text)
+	}
+	*a = arch
+	return nil
+}
+
+// Set implements pflag.Value.
+func (a *Arch) Set(s string) error {
+	return a.UnmarshalText([]byte(s))
+}
+
+// Type implements pflag.Value.
+func (a *Arch) Type() string {
+	return "arch"
+}
+
+// String returns the keywords of mode bits that joined with "+",
+// like "arm+be", the unknown bits are formatted as hex.
+func (m Mode) String() string {
+	if m == MODE_LITTLE_ENDIAN {
+		return "le"
+	}
+	var names []string
+	for _, bit := range modeBits {
+		if m&bit.mode != 0 {
+			names = append(names, bit.name)
+		}
+	}
+	if unknown := m &^ modeMask; unknown != 0 {
+		names = append(names, fmt.Sprintf("0x%X", uint(unknown)))
+	}
+	return strings.Join(names, "+")
+}
+
+// valid is used to check the mode only contains the known bits.
+func (m Mode) valid() bool {
+	return m&^modeMask == 0
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m Mode) MarshalText() ([]byte, error) {
+	if !m.valid() {
+		return nil, fmt.Errorf("invalid mode 0x%X", uint(m))
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, the keywords
+// can be joined with "+", like "arm+be".
+func (m *Mode) UnmarshalText(text []byte) error {
+	var mode Mode
+	groups := make(map[string]string)
+	for _, key := range strings.Split(string(text), "+") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		val, ok := modeM[key]
+		if !ok {
+			return fmt.Errorf("invalid mode %q", text)
+		}
+		// check the keyword is not contradictory with the previous
+		if group, ok := modeGroupM[key]; ok {
+			prev, ok := groups[group]
+			if ok && modeM[prev] != val {
+				return fmt.Errorf("contradictory mode %q: %s and %s", text, prev, key)
+			}
+			groups[group] = key
+		}
+		mode |= val
+	}
+	*m = mode
+	return nil
+}
+
+// Set implements pflag.Value.
+func (m *Mode) Set(s string) error {
+	return m.UnmarshalText([]byte(s))
+}
+
+// Type implements pflag.Value.
+func (m *Mode) Type() string {
+	return "mode"
+}
+
+func (t OptionType) String() string {
+	if key, ok := keyOf(optionM, t); ok {
+		return key
+	}
+	return fmt.Sprintf("OptionType(%d)", uint(t))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t OptionType) MarshalText() ([]byte, error) {
+	if _, ok := keyOf(optionM, t); !ok {
+		return nil, fmt.Errorf("invalid option type %d", uint(t))
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *OptionType) UnmarshalText(text []byte) error {
+	typ, ok := optionM[strings.ToLower(string(text))]
+	if !ok {
+		return fmt.Errorf("invalid option type %q", text)
+	}
+	*t = typ
+	return nil
+}
+
+// Set implements pflag.Value.
+func (t *OptionType) Set(s string) error {
+	return t.UnmarshalText([]byte(s))
+}
+
+// Type implements pflag.Value.
+func (t *OptionType) Type() string {
+	return "option"
+}
+
+// String returns the syntax keyword of value, if the value is not
+// a syntax, like the symbol resolver, it is formatted as decimal.
+func (v OptionValue) String() string {
+	if key, ok := keyOf(syntaxM, v); ok {
+		return key
+	}
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (v OptionValue) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it accepts
+// the syntax keyword or an unsigned integer.
+func (v *OptionValue) UnmarshalText(text []byte) error {
+	if val, ok := syntaxM[strings.ToLower(string(text))]; ok {
+		*v = val
+		return nil
+	}
+	val, err := strconv.ParseUint(string(text), 0, 0)
+	if err != nil {
+		return fmt.Errorf("invalid option value %q", text)
+	}
+	*v = OptionValue(val)
+	return nil
+}
+
+// Set implements pflag.Value.
+func (v *OptionValue) Set(s string) error {
+	return v.UnmarshalText([]byte(s))
+}
+
+// Type implements pflag.Value.
+func (v *OptionValue) Type() string {
+	return "value"
+}
@@ -0,0 +1,193 @@
+package keystone
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ encoding.TextMarshaler   = ARCH_X86
+	_ encoding.TextUnmarshaler = (*Arch)(nil)
+	_ pflag.Value              = (*Arch)(nil)
+	_ pflag.Value              = (*Mode)(nil)
+	_ pflag.Value              = (*OptionType)(nil)
+	_ pflag.Value              = (*OptionValue)(nil)
+)
+
+func TestArch(t *testing.T) {
+	require.Equal(t, "x86", ARCH_X86.String())
+	require.Equal(t, "arm64", ARCH_ARM64.String())
+	require.Equal(t, "Arch(999)", Arch(999).String())
+
+	for _, key := range ArchOptions() {
+		var arch Arch
+		err := arch.Set(key)
+		require.NoError(t, err)
+		require.Equal(t, key, arch.String())
+	}
+
+	var arch Arch
+	err := arch.Set("X86")
+	require.NoError(t, err)
+	require.Equal(t, ARCH_X86, arch)
+
+	err = arch.Set("foo")
+	require.EqualError(t, err, `invalid arch "foo"`)
+
+	_, err = Arch(999).MarshalText()
+	require.EqualError(t, err, "invalid arch 999")
+
+	// ARCH_MAX is not a real architecture
+	require.NotContains(t, ArchOptions(), "max")
+	_, err = ParseArch("max")
+	require.EqualError(t, err, `invalid arch "max"`)
+	_, err = ARCH_MAX.MarshalText()
+	require.EqualError(t, err, "invalid arch 11")
+}
+
+func TestMode(t *testing.T) {
+	for _, item := range []struct {
+		mode Mode
+		str  string
+	}{
+		{MODE_LITTLE_ENDIAN, "le"},
+		{MODE_32, "32"},
+		{MODE_THUMB, "thumb"},
+		{MODE_ARM | MODE_BIG_ENDIAN, "arm+be"},
+		{MODE_THUMB | MODE_V8, "thumb+v8"},
+		{Mode(0x1000), "0x1000"},
+	} {
+		require.Equal(t, item.str, item.mode.String())
+	}
+
+	var mode Mode
+	err := mode.Set("mips64+be")
+	require.NoError(t, err)
+	require.Equal(t, MODE_MIPS64|MODE_BIG_ENDIAN, mode)
+
+	err = mode.Set("foo")
+	require.EqualError(t, err, `invalid mode "foo"`)
+
+	_, err = Mode(0x1000).MarshalText()
+	require.EqualError(t, err, "invalid mode 0x1000")
+}
+
+func TestOption(t *testing.T) {
+	require.Equal(t, "syntax", OPT_SYNTAX.String())
+	require.Equal(t, "nasm", OPT_SYNTAX_NASM.String())
+	require.Equal(t, "1234", OptionValue(1234).String())
+
+	var val OptionValue
+	err := val.Set("att")
+	require.NoError(t, err)
+	require.Equal(t, OPT_SYNTAX_ATT, val)
+	err = val.Set("0x10")
+	require.NoError(t, err)
+	require.Equal(t, OPT_SYNTAX_GAS, val)
+
+	var typ OptionType
+	err = typ.Set("sym_resolver")
+	require.NoError(t, err)
+	require.Equal(t, OPT_SYM_RESOLVER, typ)
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	type config struct {
+		Arch   Arch        `json:"arch"`
+		Mode   Mode        `json:"mode"`
+		Option OptionType  `json:"option"`
+		Syntax OptionValue `json:"syntax"`
+	}
+	cfg := config{
+		Arch:   ARCH_MIPS,
+		Mode:   MODE_MIPS32 | MODE_BIG_ENDIAN,
+		Option: OPT_SYNTAX,
+		Syntax: OPT_SYNTAX_GAS,
+	}
+	data, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	expected := `{"arch":"mips","mode":"32+be","option":"syntax","syntax":"gas"}`
+	require.Equal(t, expected, string(data))
+
+	var out config
+	err = json.Unmarshal(data, &out)
+	require.NoError(t, err)
+	require.Equal(t, cfg, out)
+}
+
+func TestNewEngine_Invalid(t *testing.T) {
+	t.Run("invalid arch", func(t *testing.T) {
+		engine, err := NewEngine(Arch(999), MODE_32)
+		require.ErrorIs(t, err, ErrArch)
+		require.Nil(t, engine)
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		engine, err := NewEngine(ARCH_X86, 999)
+		require.ErrorIs(t, err, ErrMode)
+		require.EqualError(t, err, fmt.Sprintf(
+			"failed to open keystone engine: invalid mode %s for arch x86 (KS_ERR_MODE)", Mode(999),
+		))
+		require.Nil(t, engine)
+	})
+}
+
+func TestParseArch(t *testing.T) {
+	arch, err := ParseArch("MIPS")
+	require.NoError(t, err)
+	require.Equal(t, ARCH_MIPS, arch)
+
+	arch, err = ParseArch("foo")
+	require.EqualError(t, err, `invalid arch "foo"`)
+	require.Zero(t, arch)
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode("64")
+	require.NoError(t, err)
+	require.Equal(t, MODE_64, mode)
+
+	mode, err = ParseMode("foo")
+	require.EqualError(t, err, `invalid mode "foo"`)
+	require.Zero(t, mode)
+}
+
+func TestStringToMode(t *testing.T) {
+	for _, item := range []struct {
+		str  string
+		mode Mode
+	}{
+		{"32", MODE_32},
+		{"arm+be", MODE_ARM | MODE_BIG_ENDIAN},
+		{"ARM + BE", MODE_ARM | MODE_BIG_ENDIAN},
+		{"mips32+be", MODE_MIPS32 | MODE_BIG_ENDIAN},
+		{"mips64+be", MODE_MIPS64 | MODE_BIG_ENDIAN},
+		{"ppc64+be", MODE_PPC64 | MODE_BIG_ENDIAN},
+		{"thumb+v8", MODE_THUMB | MODE_V8},
+		{"thumb+v8+be", MODE_THUMB | MODE_V8 | MODE_BIG_ENDIAN},
+		{"mips32+micro", MODE_MIPS32 | MODE_MICRO},
+		{"32+mips32", MODE_MIPS32},
+	} {
+		require.Equal(t, item.mode, StringToMode(item.str), item.str)
+	}
+
+	for _, item := range []struct {
+		str string
+		err string
+	}{
+		{"le+be", `contradictory mode "le+be": le and be`},
+		{"arm+thumb", `contradictory mode "arm+thumb": arm and thumb`},
+		{"mips32+mips64", `contradictory mode "mips32+mips64": mips32 and mips64`},
+		{"32+64", `contradictory mode "32+64": 32 and 64`},
+		{"arm+", `invalid mode "arm+"`},
+	} {
+		_, err := ParseMode(item.str)
+		require.EqualError(t, err, item.err)
+		require.Zero(t, StringToMode(item.str))
+	}
+}
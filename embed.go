@@ -0,0 +1,16 @@
+//go:build !keystone_noembed
+
+package keystone
+
+import (
+	"embed"
+)
+
+// just for prevent [import _ "embed"] :)
+var _ embed.FS
+
+// compressedModule is the gzip compressed keystone wasm module,
+// it is decompressed once when the first engine is created.
+//
+//go:embed wasm/keystone.wasm.gz
+var compressedModule []byte
@@ -0,0 +1,161 @@
+package keystone
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeystoneError is returned when the keystone engine returns an error code.
+type KeystoneError struct {
+	// Op is the name of keystone API, like "ks_asm".
+	Op string
+
+	// Code is the error code from keystone.
+	Code Error
+
+	// Message is the error string from ks_strerror.
+	Message string
+
+	// Line is the line number of the statement that caused the
+	// error, start from 1, it is zero if the location is unknown.
+	Line int
+
+	// Column is the column of the statement in line, start from 1.
+	Column int
+
+	// Text is the source code of the statement.
+	Text string
+
+	// Warnings is the output lines that LLVM writes to stdout
+	// and stderr during the call, they are added to the message.
+	Warnings []string
+}
+
+// the description about the keystone API for error message.
+var opDesc = map[string]string{
+	"ks_open":   "open keystone engine",
+	"ks_option": "set keystone option",
+	"ks_asm":    "assemble",
+	"ks_close":  "close keystone engine",
+}
+
+func (e *KeystoneError) Error() string {
+	if e.Op == "" {
+		return e.Message
+	}
+	desc, ok := opDesc[e.Op]
+	if !ok {
+		desc = "call " + e.Op
+	}
+	var msg string
+	if e.Line > 0 {
+		msg = fmt.Sprintf("failed to %s at line %d, column %d: %s", desc, e.Line, e.Column, e.Message)
+	} else {
+		msg = fmt.Sprintf("failed to %s: %s", desc, e.Message)
+	}
+	for _, line := range e.Warnings {
+		msg += "\n    " + line
+	}
+	return msg
+}
+
+// Is is used to compare the error code with the target, so that
+// errors.Is(err, ErrSymbolMissing) can be used. If the Op of target
+// is not empty, it must be the same.
+func (e *KeystoneError) Is(target error) bool {
+	t, ok := target.(*KeystoneError)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Op == "" || t.Op == e.Op)
+}
+
+func newSentinel(code Error, name string) *KeystoneError {
+	return &KeystoneError{Code: code, Message: name}
+}
+
+// sentinel errors about the ERR_* constants, use errors.Is for compare.
+var (
+	ErrNoMem               = newSentinel(ERR_NOMEM, "KS_ERR_NOMEM")
+	ErrArch                = newSentinel(ERR_ARCH, "KS_ERR_ARCH")
+	ErrHandle              = newSentinel(ERR_HANDLE, "KS_ERR_HANDLE")
+	ErrMode                = newSentinel(ERR_MODE, "KS_ERR_MODE")
+	ErrVersion             = newSentinel(ERR_VERSION, "KS_ERR_VERSION")
+	ErrOptInvalid          = newSentinel(ERR_OPT_INVALID, "KS_ERR_OPT_INVALID")
+	ErrExprToken           = newSentinel(ERR_ASM_EXPR_TOKEN, "KS_ERR_ASM_EXPR_TOKEN")
+	ErrDirectiveValueRange = newSentinel(ERR_ASM_DIRECTIVE_VALUE_RANGE, "KS_ERR_ASM_DIRECTIVE_VALUE_RANGE")
+	ErrDirectiveID         = newSentinel(ERR_ASM_DIRECTIVE_ID, "KS_ERR_ASM_DIRECTIVE_ID")
+	ErrDirectiveToken      = newSentinel(ERR_ASM_DIRECTIVE_TOKEN, "KS_ERR_ASM_DIRECTIVE_TOKEN")
+	ErrDirectiveStr        = newSentinel(ERR_ASM_DIRECTIVE_STR, "KS_ERR_ASM_DIRECTIVE_STR")
+	ErrDirectiveComma      = newSentinel(ERR_ASM_DIRECTIVE_COMMA, "KS_ERR_ASM_DIRECTIVE_COMMA")
+	ErrDirectiveRelocName  = newSentinel(ERR_ASM_DIRECTIVE_RELOC_NAME, "KS_ERR_ASM_DIRECTIVE_RELOC_NAME")
+	ErrDirectiveRelocToken = newSentinel(ERR_ASM_DIRECTIVE_RELOC_TOKEN, "KS_ERR_ASM_DIRECTIVE_RELOC_TOKEN")
+	ErrDirectiveFPoint     = newSentinel(ERR_ASM_DIRECTIVE_FPOINT, "KS_ERR_ASM_DIRECTIVE_FPOINT")
+	ErrDirectiveUnknown    = newSentinel(ERR_ASM_DIRECTIVE_UNKNOWN, "KS_ERR_ASM_DIRECTIVE_UNKNOWN")
+	ErrDirectiveEqu        = newSentinel(ERR_ASM_DIRECTIVE_EQU, "KS_ERR_ASM_DIRECTIVE_EQU")
+	ErrDirectiveInvalid    = newSentinel(ERR_ASM_DIRECTIVE_INVALID, "KS_ERR_ASM_DIRECTIVE_INVALID")
+	ErrVariantInvalid      = newSentinel(ERR_ASM_VARIANT_INVALID, "KS_ERR_ASM_VARIANT_INVALID")
+	ErrExprBracket         = newSentinel(ERR_ASM_EXPR_BRACKET, "KS_ERR_ASM_EXPR_BRACKET")
+	ErrSymbolModifier      = newSentinel(ERR_ASM_SYMBOL_MODIFIER, "KS_ERR_ASM_SYMBOL_MODIFIER")
+	ErrSymbolRedefined     = newSentinel(ERR_ASM_SYMBOL_REDEFINED, "KS_ERR_ASM_SYMBOL_REDEFINED")
+	ErrSymbolMissing       = newSentinel(ERR_ASM_SYMBOL_MISSING, "KS_ERR_ASM_SYMBOL_MISSING")
+	ErrRParen              = newSentinel(ERR_ASM_RPAREN, "KS_ERR_ASM_RPAREN")
+	ErrStatToken           = newSentinel(ERR_ASM_STAT_TOKEN, "KS_ERR_ASM_STAT_TOKEN")
+	ErrUnsupported         = newSentinel(ERR_ASM_UNSUPPORTED, "KS_ERR_ASM_UNSUPPORTED")
+	ErrMacroToken          = newSentinel(ERR_ASM_MACRO_TOKEN, "KS_ERR_ASM_MACRO_TOKEN")
+	ErrMacroParen          = newSentinel(ERR_ASM_MACRO_PAREN, "KS_ERR_ASM_MACRO_PAREN")
+	ErrMacroEqu            = newSentinel(ERR_ASM_MACRO_EQU, "KS_ERR_ASM_MACRO_EQU")
+	ErrMacroArgs           = newSentinel(ERR_ASM_MACRO_ARGS, "KS_ERR_ASM_MACRO_ARGS")
+	ErrMacroLevelsExceed   = newSentinel(ERR_ASM_MACRO_LEVELS_EXCEED, "KS_ERR_ASM_MACRO_LEVELS_EXCEED")
+	ErrMacroStr            = newSentinel(ERR_ASM_MACRO_STR, "KS_ERR_ASM_MACRO_STR")
+	ErrMacroInvalid        = newSentinel(ERR_ASM_MACRO_INVALID, "KS_ERR_ASM_MACRO_INVALID")
+	ErrEscBackslash        = newSentinel(ERR_ASM_ESC_BACKSLASH, "KS_ERR_ASM_ESC_BACKSLASH")
+	ErrEscOctal            = newSentinel(ERR_ASM_ESC_OCTAL, "KS_ERR_ASM_ESC_OCTAL")
+	ErrEscSequence         = newSentinel(ERR_ASM_ESC_SEQUENCE, "KS_ERR_ASM_ESC_SEQUENCE")
+	ErrEscStr              = newSentinel(ERR_ASM_ESC_STR, "KS_ERR_ASM_ESC_STR")
+	ErrTokenInvalid        = newSentinel(ERR_ASM_TOKEN_INVALID, "KS_ERR_ASM_TOKEN_INVALID")
+	ErrInsnUnsupported     = newSentinel(ERR_ASM_INSN_UNSUPPORTED, "KS_ERR_ASM_INSN_UNSUPPORTED")
+	ErrFixupInvalid        = newSentinel(ERR_ASM_FIXUP_INVALID, "KS_ERR_ASM_FIXUP_INVALID")
+	ErrLabelInvalid        = newSentinel(ERR_ASM_LABEL_INVALID, "KS_ERR_ASM_LABEL_INVALID")
+	ErrFragmentInvalid     = newSentinel(ERR_ASM_FRAGMENT_INVALID, "KS_ERR_ASM_FRAGMENT_INVALID")
+	ErrInvalidOperand      = newSentinel(ERR_ASM_INVALIDOPERAND, "KS_ERR_ASM_INVALIDOPERAND")
+	ErrMissingFeature      = newSentinel(ERR_ASM_MISSINGFEATURE, "KS_ERR_ASM_MISSINGFEATURE")
+	ErrMnemonicFail        = newSentinel(ERR_ASM_MNEMONICFAIL, "KS_ERR_ASM_MNEMONICFAIL")
+)
+
+// CanceledError is returned when the context is done during assembly, the
+// wasm module is closed after it, and it is recovered at the next call.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("assembly canceled: %s", e.Err)
+}
+
+// Unwrap returns context.Canceled or context.DeadlineExceeded.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// ErrEngineClosed is returned when use the engine after Close.
+var ErrEngineClosed = errors.New("keystone engine is closed")
+
+// ErrEngineAborted is returned when keystone aborts, exits or throws a C++
+// exception in wasm module, the state of engine is corrupted after it, so
+// the engine is recovered with a new module instance at the next call.
+var ErrEngineAborted = errors.New("keystone engine is aborted")
+
+// abortError is the error that host functions panic with for trap the
+// wasm call, it can be compared with ErrEngineAborted by errors.Is.
+type abortError struct {
+	reason string
+}
+
+func (e *abortError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrEngineAborted, e.reason)
+}
+
+func (e *abortError) Is(target error) bool {
+	return target == ErrEngineAborted
+}
@@ -0,0 +1,176 @@
+package keystone
+
+import (
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+// the function index of imports in fake module, same as wasm.Imports.
+const (
+	fakeCxaThrow = 0
+	fakeAbortJS  = 7
+	fakeExit     = 13
+	fakeFdWrite  = 19
+)
+
+// fakeBodies is the default body of the exported functions in fake module.
+var fakeBodies = map[string][]byte{
+	// bump allocator with global 0
+	"malloc": {
+		0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6A,
+		0x41, 0x07, 0x6A, 0x41, 0x78, 0x71, 0x24, 0x00,
+	},
+	"free": {},
+	// *ptr = 1
+	"ks_open": {0x20, 0x02, 0x41, 0x01, 0x36, 0x02, 0x00, 0x41, 0x00},
+	// return ERR_OPT_INVALID if the type is unknown,
+	// otherwise store the value at address 48
+	"ks_option": {
+		0x20, 0x01, 0x41, 0x02, 0x4B, 0x04, 0x40, 0x41, 0x06, 0x0F, 0x0B,
+		0x41, 0x30, 0x20, 0x02, 0x36, 0x02, 0x00, 0x41, 0x00,
+	},
+	// write the first 6 bytes to stderr if the source starts with "p",
+	// loop forever if the source starts with "w" for wait the context,
+	// trap if the source starts with "t", "a", "e" or "u", otherwise
+	// output 0xC3 for each "n" at address 512 and count the lines, the
+	// "x" returns ERR_ASM_MNEMONICFAIL with the lines before it.
+	// The param 0 is used as output size, global 2 is statement count.
+	"ks_asm": {
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xF0, 0x00, 0x46, 0x04, 0x40,
+		0x41, 0xC0, 0x00, 0x20, 0x01, 0x36, 0x02, 0x00,
+		0x41, 0xC4, 0x00, 0x41, 0x06, 0x36, 0x02, 0x00,
+		0x41, 0x02, 0x41, 0xC0, 0x00, 0x41, 0x01, 0x41, 0xC8, 0x00,
+		0x10, fakeFdWrite, 0x1A,
+		0x0B,
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xF7, 0x00, 0x46,
+		0x04, 0x40, 0x03, 0x40, 0x0C, 0x00, 0x0B, 0x0B,
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xF5, 0x00, 0x46,
+		0x04, 0x40, 0x00, 0x0B,
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xF4, 0x00, 0x46,
+		0x04, 0x40, 0x41, 0x00, 0x41, 0x00, 0x41, 0x00, 0x10, fakeCxaThrow, 0x0B,
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xE1, 0x00, 0x46,
+		0x04, 0x40, 0x10, fakeAbortJS, 0x0B,
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xE5, 0x00, 0x46,
+		0x04, 0x40, 0x41, 0x01, 0x10, fakeExit, 0x0B,
+		0x41, 0x00, 0x21, 0x00,
+		0x41, 0x00, 0x24, 0x02,
+		0x02, 0x40, 0x03, 0x40,
+		// break at the terminator
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x45, 0x0D, 0x01,
+		// "n"
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xEE, 0x00, 0x46, 0x04, 0x40,
+		0x20, 0x00, 0x41, 0xC3, 0x01, 0x3A, 0x00, 0x80, 0x04,
+		0x20, 0x00, 0x41, 0x01, 0x6A, 0x21, 0x00,
+		0x0B,
+		// "x"
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0xF8, 0x00, 0x46, 0x04, 0x40,
+		0x41, 0x82, 0x04, 0x24, 0x01,
+		0x20, 0x05, 0x23, 0x02, 0x36, 0x02, 0x00,
+		0x41, 0x7F, 0x0F,
+		0x0B,
+		// "\n"
+		0x20, 0x01, 0x2D, 0x00, 0x00, 0x41, 0x0A, 0x46, 0x04, 0x40,
+		0x23, 0x02, 0x41, 0x01, 0x6A, 0x24, 0x02,
+		0x0B,
+		0x20, 0x01, 0x41, 0x01, 0x6A, 0x21, 0x01,
+		0x0C, 0x00,
+		0x0B, 0x0B,
+		0x20, 0x03, 0x41, 0x80, 0x04, 0x36, 0x02, 0x00,
+		0x20, 0x04, 0x20, 0x00, 0x36, 0x02, 0x00,
+		0x20, 0x05, 0x23, 0x02, 0x41, 0x01, 0x6A, 0x36, 0x02, 0x00,
+		0x41, 0x00,
+	},
+	"ks_free":     {},
+	"ks_close":    {0x41, 0x00},
+	"ks_errno":    {0x23, 0x01},
+	"ks_strerror": {0x41, 0x20},
+	"ks_version":  {0x41, 0x09},
+}
+
+// fakeModule is used to build a wasm module that has the same imports and
+// exports as keystone, the body of exported functions can be replaced for
+// inject fault, the "unreachable" instruction is 0x00.
+func fakeModule(bodies map[string][]byte) []byte {
+	section := func(bin []byte, id byte, data []byte) []byte {
+		bin = append(bin, id)
+		bin = appendU32(bin, uint32(len(data)))
+		return append(bin, data...)
+	}
+	var types []wasm.FuncType
+	typeIdx := func(typ wasm.FuncType) uint32 {
+		for i, t := range types {
+			if t.Equal(typ) {
+				return uint32(i)
+			}
+		}
+		types = append(types, typ)
+		return uint32(len(types) - 1)
+	}
+	imports := appendU32(nil, uint32(len(wasm.Imports)))
+	for _, fn := range wasm.Imports {
+		imports = appendName(imports, "a")
+		imports = appendName(imports, fn.Name)
+		imports = append(imports, wasm.ExternFunc)
+		imports = appendU32(imports, typeIdx(fn.Type))
+	}
+	var (
+		funcs   []byte
+		exports []byte
+		code    []byte
+		n       uint32
+	)
+	for _, fn := range wasm.Exports {
+		if fn.Optional {
+			continue
+		}
+		body, ok := bodies[fn.Name]
+		if !ok {
+			body = fakeBodies[fn.Name]
+		}
+		funcs = appendU32(funcs, typeIdx(fn.Type))
+		exports = appendName(exports, fn.Name)
+		exports = append(exports, wasm.ExternFunc)
+		exports = appendU32(exports, uint32(len(wasm.Imports))+n)
+		// no locals
+		body = append(append([]byte{0x00}, body...), 0x0B)
+		code = appendU32(code, uint32(len(body)))
+		code = append(code, body...)
+		n++
+	}
+	typeSec := appendU32(nil, uint32(len(types)))
+	for _, typ := range types {
+		typeSec = append(typeSec, 0x60)
+		typeSec = appendName(typeSec, string(typ.Params))
+		typeSec = appendName(typeSec, string(typ.Results))
+	}
+	exports = appendName(exports, "memory")
+	exports = append(exports, wasm.ExternMemory, 0x00)
+	exports = appendName(exports, "table")
+	exports = append(exports, wasm.ExternTable, 0x00)
+
+	bin := append([]byte{}, wasm.Magic...)
+	bin = section(bin, wasm.SectionType, typeSec)
+	bin = section(bin, wasm.SectionImport, imports)
+	bin = section(bin, wasm.SectionFunction, append(appendU32(nil, n), funcs...))
+	// function table for install symbol resolver
+	bin = section(bin, 4, []byte{0x01, 0x70, 0x00, 0x01})
+	// memory with 1 page
+	bin = section(bin, 5, []byte{0x01, 0x00, 0x01})
+	// mutable i32 globals for heap that start from 1024, errno and statement count
+	bin = section(bin, 6, []byte{
+		0x03,
+		0x7F, 0x01, 0x41, 0x80, 0x08, 0x0B,
+		0x7F, 0x01, 0x41, 0x00, 0x0B,
+		0x7F, 0x01, 0x41, 0x00, 0x0B,
+	})
+	bin = section(bin, wasm.SectionExport, append(appendU32(nil, n+2), exports...))
+	bin = section(bin, 10, append(appendU32(nil, n), code...))
+	// data: "error" at 32
+	data := []byte{0x01}
+	data = append(data, 0x00, 0x41, 0x20, 0x0B, 0x06, 'e', 'r', 'r', 'o', 'r', 0x00)
+	return section(bin, 11, data)
+}
+
+// newFakeEngine is used to create engine with the fake module.
+func newFakeEngine(bodies map[string][]byte) (*Engine, error) {
+	return NewEngineWithModule(fakeModule(bodies), ARCH_X86, MODE_32)
+}
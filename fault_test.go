@@ -0,0 +1,149 @@
+package keystone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the body of function that traps with "unreachable".
+var unreachable = []byte{0x00}
+
+func TestEngine_FaultInjection(t *testing.T) {
+	// ks_asm returns ERR_NOMEM without output
+	asmFail := []byte{0x41, 0x01}
+
+	t.Run("malloc", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{"malloc": unreachable})
+		require.ErrorContains(t, err, "failed to call malloc: wasm error: unreachable")
+		require.Nil(t, engine)
+	})
+
+	t.Run("malloc out of memory", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{"malloc": {0x41, 0x00}})
+		require.ErrorContains(t, err, "failed to allocate 12 bytes in wasm module")
+		require.Nil(t, engine)
+	})
+
+	t.Run("ks_open", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{
+			"ks_open":     {0x41, 0x01},
+			"ks_strerror": unreachable,
+		})
+		require.ErrorContains(t, err, "failed to call ks_strerror: wasm error: unreachable")
+		require.Nil(t, engine)
+	})
+
+	t.Run("ks_errno", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{
+			"ks_asm":   asmFail,
+			"ks_errno": unreachable,
+		})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		inst, err := engine.Assemble("nop", 0)
+		require.ErrorContains(t, err, "failed to call ks_errno: wasm error: unreachable")
+		require.Nil(t, inst)
+	})
+
+	t.Run("ks_strerror", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{
+			"ks_asm":      asmFail,
+			"ks_strerror": unreachable,
+		})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		inst, err := engine.Assemble("nop", 0)
+		require.ErrorContains(t, err, "failed to call ks_strerror: wasm error: unreachable")
+		require.Nil(t, inst)
+	})
+
+	t.Run("ks_open handle out of range", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{
+			"malloc":  {0x41, 0x70},
+			"ks_open": {0x41, 0x00},
+		})
+		require.ErrorContains(t, err, "failed to read wasm memory at 0xFFFFFFF0")
+		require.Nil(t, engine)
+	})
+
+	t.Run("ks_asm output out of range", func(t *testing.T) {
+		// write 0xFFFFFFF0 to the instruction address and 4 to the size
+		engine, err := newFakeEngine(map[string][]byte{"ks_asm": {
+			0x20, 0x03, 0x41, 0x70, 0x36, 0x02, 0x00,
+			0x20, 0x04, 0x41, 0x04, 0x36, 0x02, 0x00,
+			0x41, 0x00,
+		}})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		inst, err := engine.Assemble("nop", 0)
+		errStr := "failed to read 4 bytes of instruction at 0xFFFFFFF0 from wasm memory"
+		require.EqualError(t, err, errStr)
+		require.Nil(t, inst)
+		require.NotNil(t, engine.trap)
+
+		// the engine is recovered before the next call
+		inst, err = engine.Assemble("nop", 0)
+		require.EqualError(t, err, errStr)
+		require.Nil(t, inst)
+	})
+
+	t.Run("ks_option", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{"ks_option": unreachable})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		err = engine.Option(OPT_SYNTAX, OPT_SYNTAX_INTEL)
+		require.ErrorContains(t, err, "failed to call ks_option: wasm error: unreachable")
+	})
+
+	t.Run("ks_free", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{"ks_free": unreachable})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		inst, err := engine.Assemble("nop", 0)
+		require.ErrorContains(t, err, "failed to call ks_free: wasm error: unreachable")
+		require.Nil(t, inst)
+	})
+
+	t.Run("ks_close", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{"ks_close": unreachable})
+		require.NoError(t, err)
+
+		err = engine.Close()
+		require.ErrorContains(t, err, "failed to call ks_close: wasm error: unreachable")
+		require.True(t, engine.module.IsClosed())
+	})
+
+	t.Run("ks_arch_supported", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{"ks_close": unreachable})
+		require.NoError(t, err)
+
+		ok, err := engine.ArchSupported(ARCH_X86)
+		require.ErrorContains(t, err, "failed to call ks_close: wasm error: unreachable")
+		require.False(t, ok)
+		require.NotNil(t, engine.trap)
+
+		err = engine.Close()
+		require.NoError(t, err)
+	})
+
+	t.Run("closed engine", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		err = engine.Close()
+		require.NoError(t, err)
+
+		inst, err := engine.Assemble("nop", 0)
+		require.ErrorIs(t, err, ErrEngineClosed)
+		require.Nil(t, inst)
+		err = engine.Option(OPT_SYNTAX, OPT_SYNTAX_INTEL)
+		require.ErrorIs(t, err, ErrEngineClosed)
+		_, err = engine.ArchSupported(ARCH_X86)
+		require.ErrorIs(t, err, ErrEngineClosed)
+	})
+}
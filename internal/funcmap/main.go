@@ -0,0 +1,123 @@
+// Command funcmap is used to generate func_map.go and module_hash.go from the
+// keystone wasm module, the names of imported and exported functions are
+// minified by emscripten, so they are resolved from the "name" custom section,
+// the JavaScript glue code or the signature of functions. If the name of a
+// function is ambiguous, it fails and the glue code is needed.
+//
+//	go run ./internal/funcmap -wasm wasm/keystone.wasm -glue wasm/keystone.js -o func_map.go -hash module_hash.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+func main() {
+	var (
+		wasmPath string
+		gluePath string
+		output   string
+		hashPath string
+	)
+	flag.StringVar(&wasmPath, "wasm", "wasm/keystone.wasm", "keystone wasm module")
+	flag.StringVar(&gluePath, "glue", "", "JavaScript glue code generated by emscripten (optional)")
+	flag.StringVar(&output, "o", "func_map.go", "output file of function names")
+	flag.StringVar(&hashPath, "hash", "module_hash.go", "output file of module hash, empty for skip")
+	flag.Parse()
+	err := run(wasmPath, gluePath, output, hashPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "funcmap:", err)
+		os.Exit(1)
+	}
+}
+
+func run(wasmPath, gluePath, output, hashPath string) error {
+	bin, err := os.ReadFile(wasmPath)
+	if err != nil {
+		return err
+	}
+	mod, err := wasm.Parse(bin)
+	if err != nil {
+		return fmt.Errorf("failed to parse wasm module: %s", err)
+	}
+	var glue *wasm.Glue
+	if gluePath != "" {
+		js, err := os.ReadFile(gluePath)
+		if err != nil {
+			return err
+		}
+		glue, err = wasm.ParseGlue(js)
+		if err != nil {
+			return fmt.Errorf("failed to parse glue code: %s", err)
+		}
+	}
+	fm, err := wasm.Resolve(mod, glue)
+	if err != nil {
+		if glue == nil {
+			return fmt.Errorf("%s, try again with -glue", err)
+		}
+		return err
+	}
+	err = wasm.Verify(mod, fm)
+	if err != nil {
+		return err
+	}
+	src, err := generate(fm)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(output, src, 0644)
+	if err != nil {
+		return err
+	}
+	if hashPath == "" {
+		return nil
+	}
+	hash := sha256.Sum256(bin)
+	src, err = generateHash(hex.EncodeToString(hash[:]))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(hashPath, src, 0644)
+}
+
+// generate is used to build the source code of func_map.go.
+func generate(fm *wasm.FuncMap) ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteString("// Code generated by go run ./internal/funcmap; DO NOT EDIT.\n\n")
+	buf.WriteString("package keystone\n\n")
+	fmt.Fprintf(&buf, "var importModule = %q\n\n", fm.ImportModule)
+	buf.WriteString("// imported functions\nvar (\n")
+	for _, fn := range wasm.Imports {
+		fmt.Fprintf(&buf, "%s = %q\n", fn.Var(), fm.Imports[fn.Name])
+	}
+	buf.WriteString(")\n\n")
+	buf.WriteString("// exported functions\nvar (\n")
+	for _, fn := range wasm.Exports {
+		name, ok := fm.Exports[fn.Name]
+		if !ok {
+			fmt.Fprintf(&buf, "\n// %s is not exported by the wasm module.\n", fn.Name)
+		}
+		fmt.Fprintf(&buf, "%s = %q\n", fn.Var(), name)
+	}
+	buf.WriteString(")\n")
+	return format.Source(buf.Bytes())
+}
+
+// generateHash is used to build the source code of module_hash.go.
+func generateHash(hash string) ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteString("// Code generated by go run ./internal/funcmap; DO NOT EDIT.\n\n")
+	buf.WriteString("package keystone\n\n")
+	buf.WriteString("// moduleSHA256 is the hash of the wasm module that func_map.go\n")
+	buf.WriteString("// is generated from, the embedded module is checked with it.\n")
+	fmt.Fprintf(&buf, "const moduleSHA256 = %q\n", hash)
+	return format.Source(buf.Bytes())
+}
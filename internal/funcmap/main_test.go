@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+func TestGenerate(t *testing.T) {
+	// generate with the mapping in func_map.go must be the same file
+	expected, err := os.ReadFile("../../func_map.go")
+	require.NoError(t, err)
+	vars := make(map[string]string)
+	re := regexp.MustCompile(`(?m)^\s*(_\w+)\s*= "(\w*)"$`)
+	for _, m := range re.FindAllStringSubmatch(string(expected), -1) {
+		vars[m[1]] = m[2]
+	}
+	fm := wasm.FuncMap{
+		ImportModule: "a",
+		Imports:      make(map[string]string),
+		Exports:      make(map[string]string),
+	}
+	for _, fn := range wasm.Imports {
+		require.Contains(t, vars, fn.Var())
+		fm.Imports[fn.Name] = vars[fn.Var()]
+	}
+	for _, fn := range wasm.Exports {
+		require.Contains(t, vars, fn.Var())
+		if vars[fn.Var()] != "" {
+			fm.Exports[fn.Name] = vars[fn.Var()]
+		}
+	}
+	src, err := generate(&fm)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(src))
+}
+
+func TestGenerateHash(t *testing.T) {
+	// generate with the hash in module_hash.go must be the same file
+	expected, err := os.ReadFile("../../module_hash.go")
+	require.NoError(t, err)
+	hash := regexp.MustCompile(`const moduleSHA256 = "(\w*)"`).FindStringSubmatch(string(expected))
+	require.NotNil(t, hash)
+	src, err := generateHash(hash[1])
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(src))
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	output := dir + "/func_map.go"
+	hashPath := dir + "/module_hash.go"
+
+	t.Run("not exist", func(t *testing.T) {
+		err := run(dir+"/keystone.wasm", "", output, hashPath)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid module", func(t *testing.T) {
+		path := dir + "/invalid.wasm"
+		err := os.WriteFile(path, []byte("invalid"), 0600)
+		require.NoError(t, err)
+		err = run(path, "", output, hashPath)
+		require.ErrorContains(t, err, "failed to parse wasm module")
+		require.NoFileExists(t, output)
+	})
+}
@@ -0,0 +1,348 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Func is a function that imported or exported by keystone wasm module,
+// the Name is the C name, the Go variable name is the JavaScript name
+// that generated by emscripten, it is the C name with prefix "_".
+type Func struct {
+	Name string
+	Type FuncType
+
+	// Optional is the function that not exported by all modules.
+	Optional bool
+}
+
+// Var returns the variable name of function in func_map.go.
+func (f Func) Var() string {
+	return "_" + f.Name
+}
+
+func sig(params string, results string) FuncType {
+	parse := func(s string) []byte {
+		types := make([]byte, 0, len(s))
+		for _, c := range s {
+			switch c {
+			case 'i':
+				types = append(types, I32)
+			case 'I':
+				types = append(types, I64)
+			}
+		}
+		return types
+	}
+	return FuncType{Params: parse(params), Results: parse(results)}
+}
+
+// Imports is the functions that keystone wasm module imported,
+// they are provided by the host module.
+var Imports = []Func{
+	{Name: "__cxa_throw", Type: sig("iii", "")},
+	{Name: "__syscall_fstat64", Type: sig("ii", "i")},
+	{Name: "__syscall_getcwd", Type: sig("ii", "i")},
+	{Name: "__syscall_lstat64", Type: sig("ii", "i")},
+	{Name: "__syscall_newfstatat", Type: sig("iiii", "i")},
+	{Name: "__syscall_openat", Type: sig("iiii", "i")},
+	{Name: "__syscall_stat64", Type: sig("ii", "i")},
+	{Name: "_abort_js", Type: sig("", "")},
+	{Name: "_mmap_js", Type: sig("iiiiIii", "i")},
+	{Name: "_munmap_js", Type: sig("iiiiiI", "i")},
+	{Name: "emscripten_resize_heap", Type: sig("i", "i")},
+	{Name: "environ_get", Type: sig("ii", "i")},
+	{Name: "environ_sizes_get", Type: sig("ii", "i")},
+	{Name: "exit", Type: sig("i", "")},
+	{Name: "fd_close", Type: sig("i", "i")},
+	{Name: "fd_fdstat_get", Type: sig("ii", "i")},
+	{Name: "fd_pread", Type: sig("iiiIi", "i")},
+	{Name: "fd_read", Type: sig("iiii", "i")},
+	{Name: "fd_seek", Type: sig("iIii", "i")},
+	{Name: "fd_write", Type: sig("iiii", "i")},
+}
+
+// Exports is the functions that keystone wasm module exported.
+var Exports = []Func{
+	{Name: "malloc", Type: sig("i", "i")},
+	{Name: "free", Type: sig("i", "")},
+	{Name: "ks_open", Type: sig("iii", "i")},
+	{Name: "ks_option", Type: sig("iii", "i")},
+	{Name: "ks_asm", Type: sig("iiIiii", "i")},
+	{Name: "ks_free", Type: sig("i", "")},
+	{Name: "ks_close", Type: sig("i", "i")},
+	{Name: "ks_errno", Type: sig("i", "i")},
+	{Name: "ks_strerror", Type: sig("i", "i")},
+	{Name: "ks_version", Type: sig("ii", "i")},
+	{Name: "ks_arch_supported", Type: sig("i", "i"), Optional: true},
+}
+
+// FuncMap is the mapping from the C name to the name in wasm module.
+type FuncMap struct {
+	ImportModule string
+	Imports      map[string]string
+	Exports      map[string]string
+}
+
+// Glue contains the name mapping that read from the JavaScript glue code
+// generated by emscripten, the key is the JavaScript name like "_ks_asm".
+type Glue struct {
+	Imports map[string]string
+	Exports map[string]string
+}
+
+var (
+	glueImportsRe = regexp.MustCompile(`(?:wasmImports|asmLibraryArg)\s*=\s*\{([^}]*)\}`)
+	gluePairRe    = regexp.MustCompile(`["']?([A-Za-z0-9_$]+)["']?\s*:\s*([A-Za-z0-9_$]+)`)
+	glueExportRe  = regexp.MustCompile(`(_[A-Za-z0-9_$]+)\s*=[^;,\n]*?wasmExports\[["']([A-Za-z0-9_$]+)["']\]`)
+)
+
+// ParseGlue is used to read the name mapping from the JavaScript glue code.
+func ParseGlue(js []byte) (*Glue, error) {
+	glue := Glue{
+		Imports: make(map[string]string),
+		Exports: make(map[string]string),
+	}
+	m := glueImportsRe.FindSubmatch(js)
+	if m == nil {
+		return nil, errors.New("wasmImports is not found in glue code")
+	}
+	for _, pair := range gluePairRe.FindAllSubmatch(m[1], -1) {
+		glue.Imports[string(pair[2])] = string(pair[1])
+	}
+	for _, pair := range glueExportRe.FindAllSubmatch(js, -1) {
+		glue.Exports[string(pair[1])] = string(pair[2])
+	}
+	if len(glue.Exports) == 0 {
+		return nil, errors.New("wasmExports is not found in glue code")
+	}
+	return &glue, nil
+}
+
+// entry is an import or export function that need to be named.
+type entry struct {
+	name string
+	typ  FuncType
+	idx  uint32
+	used bool
+}
+
+// Resolve is used to find the names of the keystone functions in wasm module,
+// the glue can be nil. Each function is resolved with the following order:
+//
+//  1. the name in module is the C name, if the module is not minified.
+//  2. the function name in the "name" custom section.
+//  3. the name mapping in the JavaScript glue code.
+//  4. the signature is unique in the remaining functions.
+//
+// If the signature of a function is the same as the others, the error reports
+// it is ambiguous, the name section or the glue code is needed to resolve it.
+func Resolve(mod *Module, glue *Glue) (*FuncMap, error) {
+	fm := FuncMap{
+		Imports: make(map[string]string),
+		Exports: make(map[string]string),
+	}
+	importModule, imports, exports, err := collect(mod)
+	if err != nil {
+		return nil, err
+	}
+	fm.ImportModule = importModule
+	var glueImports, glueExports map[string]string
+	if glue != nil {
+		glueImports, glueExports = glue.Imports, glue.Exports
+	}
+	err = resolve(fm.Imports, Imports, imports, mod.Names, glueImports)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve imported functions: %s", err)
+	}
+	for _, imp := range imports {
+		if !imp.used {
+			return nil, fmt.Errorf("unknown imported function %s.%s %s", fm.ImportModule, imp.name, imp.typ)
+		}
+	}
+	err = resolve(fm.Exports, Exports, exports, mod.Names, glueExports)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve exported functions: %s", err)
+	}
+	return &fm, nil
+}
+
+// collect is used to read the imported and exported functions in module,
+// all the imported functions must be in the same import module.
+func collect(mod *Module) (string, []*entry, []*entry, error) {
+	var (
+		importModule string
+		imports      []*entry
+		exports      []*entry
+		funcIdx      uint32
+	)
+	for _, imp := range mod.Imports {
+		if imp.Kind != ExternFunc {
+			continue
+		}
+		if importModule == "" {
+			importModule = imp.Module
+		} else if imp.Module != importModule {
+			return "", nil, nil, fmt.Errorf("function %s.%s is not in import module %s",
+				imp.Module, imp.Name, importModule)
+		}
+		typ, _ := mod.FuncType(funcIdx)
+		imports = append(imports, &entry{name: imp.Name, typ: typ, idx: funcIdx})
+		funcIdx++
+	}
+	for _, exp := range mod.Exports {
+		if exp.Kind != ExternFunc {
+			continue
+		}
+		typ, ok := mod.FuncType(exp.Index)
+		if !ok {
+			return "", nil, nil, fmt.Errorf("invalid function index of export %s", exp.Name)
+		}
+		exports = append(exports, &entry{name: exp.Name, typ: typ, idx: exp.Index})
+	}
+	return importModule, imports, exports, nil
+}
+
+// Verify is used to check the hand-kept function map matches the module,
+// each mapped function must exist with the expected signature, every
+// imported function of module must be mapped, and the optional exported
+// function can be mapped to the empty name if the module not export it.
+func Verify(mod *Module, fm *FuncMap) error {
+	importModule, imports, exports, err := collect(mod)
+	if err != nil {
+		return err
+	}
+	if len(imports) != 0 && importModule != fm.ImportModule {
+		return fmt.Errorf("import module is %q, expected %q", importModule, fm.ImportModule)
+	}
+	err = verify(fm.Imports, Imports, imports)
+	if err != nil {
+		return fmt.Errorf("invalid imported functions: %s", err)
+	}
+	for _, imp := range imports {
+		if !imp.used {
+			return fmt.Errorf("imported function %s.%s %s is not mapped", importModule, imp.name, imp.typ)
+		}
+	}
+	err = verify(fm.Exports, Exports, exports)
+	if err != nil {
+		return fmt.Errorf("invalid exported functions: %s", err)
+	}
+	return nil
+}
+
+func verify(mapping map[string]string, funcs []Func, entries []*entry) error {
+	for _, fn := range funcs {
+		name := mapping[fn.Name]
+		if name == "" {
+			if fn.Optional {
+				continue
+			}
+			return fmt.Errorf("%s is not mapped", fn.Name)
+		}
+		var found *entry
+		for _, e := range entries {
+			if e.name == name {
+				found = e
+				break
+			}
+		}
+		switch {
+		case found == nil:
+			return fmt.Errorf("%s is mapped to %q that not exists", fn.Name, name)
+		case found.used:
+			return fmt.Errorf("%s is mapped to %q that already used", fn.Name, name)
+		case !found.typ.Equal(fn.Type):
+			return fmt.Errorf("%s has signature %s, expected %s", fn.Name, found.typ, fn.Type)
+		}
+		found.used = true
+	}
+	return nil
+}
+
+func resolve(result map[string]string, funcs []Func, entries []*entry, names map[uint32]string, glue map[string]string) error {
+	match := func(fn Func, cond func(e *entry) bool) {
+		if _, ok := result[fn.Name]; ok {
+			return
+		}
+		for _, e := range entries {
+			if !e.used && cond(e) {
+				e.used = true
+				result[fn.Name] = e.name
+				return
+			}
+		}
+	}
+	for _, fn := range funcs {
+		match(fn, func(e *entry) bool {
+			return e.name == fn.Name || e.name == fn.Var()
+		})
+		match(fn, func(e *entry) bool {
+			name := strings.TrimPrefix(names[e.idx], "env.")
+			return name == fn.Name
+		})
+		match(fn, func(e *entry) bool {
+			name, ok := glue[fn.Var()]
+			return ok && e.name == name
+		})
+	}
+	// the signature of remaining functions must be unique
+	ambiguous := make(map[string][]string)
+	for _, fn := range funcs {
+		if _, ok := result[fn.Name]; ok {
+			continue
+		}
+		var same int
+		for _, f := range funcs {
+			if _, ok := result[f.Name]; !ok && f.Type.Equal(fn.Type) {
+				same++
+			}
+		}
+		var found []*entry
+		for _, e := range entries {
+			if !e.used && e.typ.Equal(fn.Type) {
+				found = append(found, e)
+			}
+		}
+		if same == 1 && len(found) == 1 {
+			found[0].used = true
+			result[fn.Name] = found[0].name
+			continue
+		}
+		for _, e := range found {
+			ambiguous[fn.Name] = append(ambiguous[fn.Name], e.name)
+		}
+	}
+	var missing []string
+	for _, fn := range funcs {
+		if _, ok := result[fn.Name]; ok || fn.Optional {
+			continue
+		}
+		names := ambiguous[fn.Name]
+		if len(names) > 1 {
+			missing = append(missing, fmt.Sprintf("%s is ambiguous in %s", fn.Name, strings.Join(names, ", ")))
+		} else {
+			missing = append(missing, fn.Name+" not found")
+		}
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return errors.New(strings.Join(missing, "; "))
+	}
+	// check the signature of resolved functions
+	for _, fn := range funcs {
+		name, ok := result[fn.Name]
+		if !ok {
+			continue
+		}
+		for _, e := range entries {
+			if e.name == name && !e.typ.Equal(fn.Type) {
+				return fmt.Errorf("%s has signature %s, expected %s", fn.Name, e.typ, fn.Type)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func appendU32(b []byte, v uint32) []byte {
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	b = appendU32(b, uint32(len(name)))
+	return append(b, name...)
+}
+
+func appendSection(b []byte, id byte, data []byte) []byte {
+	b = append(b, id)
+	b = appendU32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// buildModule is used to build a wasm module that only contains the sections
+// for resolve function names, the functions in Imports and Exports have no
+// body, and their names are generated by the name functions.
+func buildModule(importName, exportName func(i int, fn Func) string, names bool) []byte {
+	var types []FuncType
+	typeIdx := func(typ FuncType) uint32 {
+		for i, t := range types {
+			if t.Equal(typ) {
+				return uint32(i)
+			}
+		}
+		types = append(types, typ)
+		return uint32(len(types) - 1)
+	}
+	imports := appendU32(nil, uint32(len(Imports)))
+	for i, fn := range Imports {
+		imports = appendName(imports, "a")
+		imports = appendName(imports, importName(i, fn))
+		imports = append(imports, ExternFunc)
+		imports = appendU32(imports, typeIdx(fn.Type))
+	}
+	funcs := appendU32(nil, uint32(len(Exports)))
+	exports := appendU32(nil, uint32(len(Exports)+1))
+	exports = appendName(exports, "memory")
+	exports = append(exports, ExternMemory, 0x00)
+	var nameMap []byte
+	nameMap = appendU32(nameMap, uint32(len(Imports)+len(Exports)))
+	for i, fn := range Imports {
+		nameMap = appendU32(nameMap, uint32(i))
+		nameMap = appendName(nameMap, fn.Name)
+	}
+	for i, fn := range Exports {
+		idx := uint32(len(Imports) + i)
+		funcs = appendU32(funcs, typeIdx(fn.Type))
+		exports = appendName(exports, exportName(i, fn))
+		exports = append(exports, ExternFunc)
+		exports = appendU32(exports, idx)
+		nameMap = appendU32(nameMap, idx)
+		nameMap = appendName(nameMap, fn.Name)
+	}
+	typeSec := appendU32(nil, uint32(len(types)))
+	for _, typ := range types {
+		typeSec = append(typeSec, 0x60)
+		typeSec = appendName(typeSec, string(typ.Params))
+		typeSec = appendName(typeSec, string(typ.Results))
+	}
+	bin := append([]byte{}, Magic...)
+	bin = appendSection(bin, SectionType, typeSec)
+	bin = appendSection(bin, SectionImport, imports)
+	bin = appendSection(bin, SectionFunction, funcs)
+	bin = appendSection(bin, SectionExport, exports)
+	if names {
+		custom := appendName(nil, "name")
+		custom = append(custom, 0x01)
+		custom = appendName(custom, string(nameMap))
+		bin = appendSection(bin, SectionCustom, custom)
+	}
+	return bin
+}
+
+func minified(i int, _ Func) string {
+	return string(rune('A' + i))
+}
+
+func TestParse(t *testing.T) {
+	bin := buildModule(minified, minified, true)
+	mod, err := Parse(bin)
+	require.NoError(t, err)
+	require.Len(t, mod.Imports, len(Imports))
+	require.Len(t, mod.Funcs, len(Exports))
+	require.Len(t, mod.Exports, len(Exports)+1)
+	require.Equal(t, uint32(len(Imports)), mod.NumImportedFuncs())
+	require.Equal(t, "fd_write", mod.Names[uint32(len(Imports)-1)])
+
+	typ, ok := mod.FuncType(uint32(len(Imports) + 4))
+	require.True(t, ok)
+	require.Equal(t, "(i32, i32, i64, i32, i32, i32) -> (i32)", typ.String())
+	_, ok = mod.FuncType(1000)
+	require.False(t, ok)
+
+	name, ok := mod.ExportName(ExternMemory)
+	require.True(t, ok)
+	require.Equal(t, "memory", name)
+	_, ok = mod.ExportName(ExternTable)
+	require.False(t, ok)
+
+	t.Run("invalid header", func(t *testing.T) {
+		_, err := Parse([]byte("wasm"))
+		require.EqualError(t, err, "invalid wasm module header")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := Parse(bin[:len(bin)-3])
+		require.Error(t, err)
+	})
+}
+
+func TestResolve(t *testing.T) {
+	t.Run("not minified", func(t *testing.T) {
+		name := func(_ int, fn Func) string { return fn.Name }
+		mod, err := Parse(buildModule(name, name, false))
+		require.NoError(t, err)
+		fm, err := Resolve(mod, nil)
+		require.NoError(t, err)
+		require.Equal(t, "a", fm.ImportModule)
+		require.Equal(t, "ks_asm", fm.Exports["ks_asm"])
+		require.Equal(t, "__cxa_throw", fm.Imports["__cxa_throw"])
+	})
+
+	t.Run("name section", func(t *testing.T) {
+		mod, err := Parse(buildModule(minified, minified, true))
+		require.NoError(t, err)
+		fm, err := Resolve(mod, nil)
+		require.NoError(t, err)
+		for i, fn := range Imports {
+			require.Equal(t, minified(i, fn), fm.Imports[fn.Name])
+		}
+		for i, fn := range Exports {
+			require.Equal(t, minified(i, fn), fm.Exports[fn.Name])
+		}
+	})
+
+	t.Run("glue", func(t *testing.T) {
+		mod, err := Parse(buildModule(minified, minified, false))
+		require.NoError(t, err)
+		js := []byte(`var wasmImports = {A: ___cxa_throw, B: ___syscall_fstat64,
+C: ___syscall_getcwd, D: ___syscall_lstat64, E: ___syscall_newfstatat,
+F: ___syscall_openat, G: ___syscall_stat64, H: __abort_js, I: __mmap_js,
+J: __munmap_js, K: _emscripten_resize_heap, L: _environ_get,
+M: _environ_sizes_get, N: _exit, O: _fd_close, P: _fd_fdstat_get,
+Q: _fd_pread, R: _fd_read, S: _fd_seek, T: _fd_write};
+var _malloc = Module["_malloc"] = wasmExports["A"];
+var _free = Module["_free"] = wasmExports["B"];
+var _ks_open = Module["_ks_open"] = (a0, a1, a2) => (_ks_open = Module["_ks_open"] = wasmExports["C"])(a0, a1, a2);
+var _ks_option = Module["_ks_option"] = wasmExports["D"];
+var _ks_asm = wasmExports["E"];
+var _ks_free = Module["_ks_free"] = wasmExports["F"];
+var _ks_close = Module["_ks_close"] = wasmExports["G"];
+var _ks_errno = Module["_ks_errno"] = wasmExports["H"];
+var _ks_strerror = Module["_ks_strerror"] = wasmExports["I"];
+var _ks_version = Module["_ks_version"] = wasmExports["J"];`)
+		glue, err := ParseGlue(js)
+		require.NoError(t, err)
+		fm, err := Resolve(mod, glue)
+		require.NoError(t, err)
+		require.Equal(t, "C", fm.Exports["ks_open"])
+		require.Equal(t, "E", fm.Exports["ks_asm"])
+		require.Equal(t, "T", fm.Imports["fd_write"])
+		// resolved by the unique signature
+		require.Equal(t, "K", fm.Exports["ks_arch_supported"])
+	})
+
+	t.Run("ambiguous signature", func(t *testing.T) {
+		mod, err := Parse(buildModule(minified, minified, false))
+		require.NoError(t, err)
+		_, err = Resolve(mod, nil)
+		require.ErrorContains(t, err, "fd_write is ambiguous in E, F, R, T")
+	})
+
+	t.Run("unique signature", func(t *testing.T) {
+		name := func(_ int, fn Func) string { return fn.Name }
+		importName := func(i int, fn Func) string {
+			if i == 0 {
+				return "unknown"
+			}
+			return fn.Name
+		}
+		mod, err := Parse(buildModule(importName, name, false))
+		require.NoError(t, err)
+		fm, err := Resolve(mod, nil)
+		require.NoError(t, err)
+		require.Equal(t, "unknown", fm.Imports["__cxa_throw"])
+	})
+}
+
+func TestVerify(t *testing.T) {
+	mod, err := Parse(buildModule(minified, minified, false))
+	require.NoError(t, err)
+	newMap := func() *FuncMap {
+		fm := FuncMap{
+			ImportModule: "a",
+			Imports:      make(map[string]string),
+			Exports:      make(map[string]string),
+		}
+		for i, fn := range Imports {
+			fm.Imports[fn.Name] = minified(i, fn)
+		}
+		for i, fn := range Exports {
+			fm.Exports[fn.Name] = minified(i, fn)
+		}
+		return &fm
+	}
+
+	t.Run("common", func(t *testing.T) {
+		err := Verify(mod, newMap())
+		require.NoError(t, err)
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		fm := newMap()
+		fm.Exports["ks_arch_supported"] = ""
+		err := Verify(mod, fm)
+		require.NoError(t, err)
+	})
+
+	t.Run("import module", func(t *testing.T) {
+		fm := newMap()
+		fm.ImportModule = "env"
+		err := Verify(mod, fm)
+		require.EqualError(t, err, `import module is "a", expected "env"`)
+	})
+
+	t.Run("not mapped", func(t *testing.T) {
+		fm := newMap()
+		fm.Exports["ks_asm"] = ""
+		err := Verify(mod, fm)
+		require.EqualError(t, err, "invalid exported functions: ks_asm is not mapped")
+	})
+
+	t.Run("not exists", func(t *testing.T) {
+		fm := newMap()
+		fm.Imports["fd_write"] = "Z"
+		err := Verify(mod, fm)
+		require.EqualError(t, err, `invalid imported functions: fd_write is mapped to "Z" that not exists`)
+	})
+
+	t.Run("already used", func(t *testing.T) {
+		fm := newMap()
+		fm.Exports["ks_option"] = fm.Exports["ks_open"]
+		err := Verify(mod, fm)
+		require.EqualError(t, err, `invalid exported functions: ks_option is mapped to "C" that already used`)
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		fm := newMap()
+		fm.Exports["malloc"], fm.Exports["free"] = fm.Exports["free"], fm.Exports["malloc"]
+		err := Verify(mod, fm)
+		require.EqualError(t, err, "invalid exported functions: malloc has signature (i32) -> (), expected (i32) -> (i32)")
+	})
+}
@@ -0,0 +1,424 @@
+// Package wasm is used to parse the sections of wasm module that
+// needed for resolve the function names of keystone wasm module.
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// section id, see the WebAssembly core specification.
+const (
+	SectionCustom   = 0
+	SectionType     = 1
+	SectionImport   = 2
+	SectionFunction = 3
+	SectionExport   = 7
+)
+
+// extern kind of import and export.
+const (
+	ExternFunc   = 0x00
+	ExternTable  = 0x01
+	ExternMemory = 0x02
+	ExternGlobal = 0x03
+)
+
+// value type.
+const (
+	I32 = 0x7F
+	I64 = 0x7E
+	F32 = 0x7D
+	F64 = 0x7C
+)
+
+// Magic is the header of wasm module with version 1.
+var Magic = []byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00}
+
+// FuncType is the signature of function.
+type FuncType struct {
+	Params  []byte
+	Results []byte
+}
+
+func (t FuncType) String() string {
+	return fmt.Sprintf("(%s) -> (%s)", typeNames(t.Params), typeNames(t.Results))
+}
+
+// Equal is used to compare the signature.
+func (t FuncType) Equal(o FuncType) bool {
+	return bytes.Equal(t.Params, o.Params) && bytes.Equal(t.Results, o.Results)
+}
+
+func typeNames(types []byte) string {
+	buf := bytes.Buffer{}
+	for i, typ := range types {
+		if i != 0 {
+			buf.WriteString(", ")
+		}
+		switch typ {
+		case I32:
+			buf.WriteString("i32")
+		case I64:
+			buf.WriteString("i64")
+		case F32:
+			buf.WriteString("f32")
+		case F64:
+			buf.WriteString("f64")
+		default:
+			fmt.Fprintf(&buf, "0x%02X", typ)
+		}
+	}
+	return buf.String()
+}
+
+// Import is an entry in the import section.
+type Import struct {
+	Module string
+	Name   string
+	Kind   byte
+
+	// Type is the index of type, only for function.
+	Type uint32
+}
+
+// Export is an entry in the export section.
+type Export struct {
+	Name  string
+	Kind  byte
+	Index uint32
+}
+
+// Module contains the parsed sections of wasm module.
+type Module struct {
+	Types   []FuncType
+	Imports []Import
+	Exports []Export
+
+	// Funcs contains the type index of functions that defined in module.
+	Funcs []uint32
+
+	// Names contains the function names from the "name" custom
+	// section, the key is the function index.
+	Names map[uint32]string
+}
+
+// Parse is used to parse the wasm module.
+func Parse(bin []byte) (*Module, error) {
+	if !bytes.HasPrefix(bin, Magic) {
+		return nil, errors.New("invalid wasm module header")
+	}
+	mod := Module{
+		Names: make(map[uint32]string),
+	}
+	r := &reader{buf: bin, off: len(Magic)}
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.bytes(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid section %d: %s", id, err)
+		}
+		sr := &reader{buf: data}
+		switch id {
+		case SectionCustom:
+			err = mod.parseCustom(sr)
+		case SectionType:
+			err = mod.parseType(sr)
+		case SectionImport:
+			err = mod.parseImport(sr)
+		case SectionFunction:
+			err = mod.parseFunction(sr)
+		case SectionExport:
+			err = mod.parseExport(sr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid section %d: %s", id, err)
+		}
+	}
+	return &mod, nil
+}
+
+func (m *Module) parseType(r *reader) error {
+	return r.vec(func() error {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return fmt.Errorf("invalid function type 0x%02X", form)
+		}
+		var typ FuncType
+		typ.Params, err = r.name()
+		if err != nil {
+			return err
+		}
+		typ.Results, err = r.name()
+		if err != nil {
+			return err
+		}
+		m.Types = append(m.Types, typ)
+		return nil
+	})
+}
+
+func (m *Module) parseImport(r *reader) error {
+	return r.vec(func() error {
+		var (
+			imp Import
+			err error
+		)
+		module, err := r.name()
+		if err != nil {
+			return err
+		}
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		imp.Module, imp.Name = string(module), string(name)
+		imp.Kind, err = r.byte()
+		if err != nil {
+			return err
+		}
+		switch imp.Kind {
+		case ExternFunc:
+			imp.Type, err = r.u32()
+		case ExternTable:
+			_, err = r.byte()
+			if err == nil {
+				err = r.limits()
+			}
+		case ExternMemory:
+			err = r.limits()
+		case ExternGlobal:
+			_, err = r.bytes(2)
+		default:
+			err = fmt.Errorf("invalid import kind 0x%02X", imp.Kind)
+		}
+		if err != nil {
+			return err
+		}
+		m.Imports = append(m.Imports, imp)
+		return nil
+	})
+}
+
+func (m *Module) parseFunction(r *reader) error {
+	return r.vec(func() error {
+		typ, err := r.u32()
+		if err != nil {
+			return err
+		}
+		m.Funcs = append(m.Funcs, typ)
+		return nil
+	})
+}
+
+func (m *Module) parseExport(r *reader) error {
+	return r.vec(func() error {
+		var exp Export
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		exp.Name = string(name)
+		exp.Kind, err = r.byte()
+		if err != nil {
+			return err
+		}
+		exp.Index, err = r.u32()
+		if err != nil {
+			return err
+		}
+		m.Exports = append(m.Exports, exp)
+		return nil
+	})
+}
+
+// parseCustom is used to read the function names in the "name" section.
+func (m *Module) parseCustom(r *reader) error {
+	name, err := r.name()
+	if err != nil {
+		return err
+	}
+	if string(name) != "name" {
+		return nil
+	}
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return err
+		}
+		data, err := r.name()
+		if err != nil {
+			return err
+		}
+		// only the function names subsection
+		if id != 1 {
+			continue
+		}
+		sr := &reader{buf: data}
+		err = sr.vec(func() error {
+			idx, err := sr.u32()
+			if err != nil {
+				return err
+			}
+			name, err := sr.name()
+			if err != nil {
+				return err
+			}
+			m.Names[idx] = string(name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NumImportedFuncs returns the number of imported functions.
+func (m *Module) NumImportedFuncs() uint32 {
+	var n uint32
+	for _, imp := range m.Imports {
+		if imp.Kind == ExternFunc {
+			n++
+		}
+	}
+	return n
+}
+
+// FuncType returns the signature of function with the index,
+// the imported functions are in front of the defined functions.
+func (m *Module) FuncType(idx uint32) (FuncType, bool) {
+	var typ uint32
+	numImport := m.NumImportedFuncs()
+	if idx < numImport {
+		var i uint32
+		for _, imp := range m.Imports {
+			if imp.Kind != ExternFunc {
+				continue
+			}
+			if i == idx {
+				typ = imp.Type
+				break
+			}
+			i++
+		}
+	} else {
+		idx -= numImport
+		if idx >= uint32(len(m.Funcs)) {
+			return FuncType{}, false
+		}
+		typ = m.Funcs[idx]
+	}
+	if typ >= uint32(len(m.Types)) {
+		return FuncType{}, false
+	}
+	return m.Types[typ], true
+}
+
+// ExportName returns the name of the first export with the kind.
+func (m *Module) ExportName(kind byte) (string, bool) {
+	for _, exp := range m.Exports {
+		if exp.Kind == kind {
+			return exp.Name, true
+		}
+	}
+	return "", false
+}
+
+// reader is used to read the wasm binary format.
+type reader struct {
+	buf []byte
+	off int
+}
+
+func (r *reader) eof() bool {
+	return r.off >= len(r.buf)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.eof() {
+		return 0, errors.New("unexpected end of wasm module")
+	}
+	b := r.buf[r.off]
+	r.off++
+	return b, nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	var (
+		val   uint32
+		shift uint
+	)
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift == 28 && b > 0x0F {
+			return 0, errors.New("invalid LEB128 encoded integer")
+		}
+		val |= uint32(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return val, nil
+		}
+		shift += 7
+	}
+}
+
+func (r *reader) bytes(n uint32) ([]byte, error) {
+	if uint64(r.off)+uint64(n) > uint64(len(r.buf)) {
+		return nil, errors.New("unexpected end of wasm module")
+	}
+	b := r.buf[r.off : r.off+int(n)]
+	r.off += int(n)
+	return b, nil
+}
+
+// name is used to read a vector of bytes.
+func (r *reader) name() ([]byte, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	return r.bytes(n)
+}
+
+func (r *reader) limits() error {
+	flag, err := r.byte()
+	if err != nil {
+		return err
+	}
+	_, err = r.u32()
+	if err != nil {
+		return err
+	}
+	if flag&0x01 != 0 {
+		_, err = r.u32()
+	}
+	return err
+}
+
+func (r *reader) vec(fn func() error) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		err = fn()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
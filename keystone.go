@@ -3,28 +3,31 @@ package keystone
 import (
 	"bytes"
 	"context"
-	"embed"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
-)
 
-// just for prevent [import _ "embed"] :)
-var _ embed.FS
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
 
-//go:embed wasm/keystone.wasm
-var module []byte
+//go:generate go run ./internal/funcmap -wasm wasm/keystone.wasm -glue wasm/keystone.js -o func_map.go -hash module_hash.go
 
-// Engine contain wasm runtime and keystone engine.
+// Engine contain wasm module instance and keystone engine.
+// It is not safe for concurrent use, use Pool for share engines.
+// If a wasm call traps, like keystone aborts, the call returns the error
+// and the engine will be recovered with the same options at the next call.
 type Engine struct {
 	arch Arch
 	mode Mode
 
-	context context.Context
-	runtime wazero.Runtime
-	module  api.Module
-	memory  api.Memory
+	id       uint64
+	context  context.Context
+	compiled *compiled
+	module   api.Module
+	memory   api.Memory
 
 	_malloc     api.Function
 	_free       api.Function
@@ -37,218 +40,369 @@ type Engine struct {
 	_ksStrerror api.Function
 	_ksVersion  api.Function
 
+	_ksArchSupported api.Function
+
 	engine  uint64
 	version string
+
+	resolver     SymbolResolver
+	resolverFn   uint32
+	resolverMods []api.Module
+
+	// options is the options that set by Option, they
+	// are applied again when the engine is recovered.
+	options []option
+
+	// trap is the error of the last wasm call that trapped, the
+	// engine is recovered before the next call if it is not nil.
+	trap   error
+	closed bool
+
+	// released is true if the compiled module is released by Close.
+	released bool
+
+	// the statistics of engine, see Stats.
+	calls        map[string]uint64
+	liveAllocs   int
+	assembleTime time.Duration
+
+	// arena is the scratch memory that reused by each assembly.
+	arena arena
 }
 
 // NewEngine is used to create keystone engine above wasm interpreter.
+// The keystone wasm module is compiled once and shared by all engines.
+// The module is selected in the order of SetModule, the path in environment
+// variable KEYSTONE_WASM and the embedded module, if it is built with tag
+// keystone_noembed and no module is supplied, ErrNoModule is returned.
 func NewEngine(arch Arch, mode Mode) (*Engine, error) {
-	ctx := context.Background()
-	// prevent generate RWX memory
-	rc := wazero.NewRuntimeConfigInterpreter()
-	runtime := wazero.NewRuntimeWithConfig(ctx, rc)
-	// if failed to create engine, close the wasm runtime
-	var ok bool
-	defer func() {
-		if !ok {
-			_ = runtime.Close(ctx)
-		}
-	}()
-	// load keystone wasm module
-	err := processImport(runtime)
+	return NewEngineContext(context.Background(), arch, mode)
+}
+
+// NewEngineContext is like NewEngine but the context is used for instantiate
+// the wasm module and open the keystone engine. It is not used after the
+// engine is created, use AssembleContext for set the context of assembly.
+func NewEngineContext(ctx context.Context, arch Arch, mode Mode) (*Engine, error) {
+	return NewEngineWithConfig(ctx, arch, mode, nil)
+}
+
+// NewEngineWithConfig is like NewEngineContext but with the config about the
+// wasm runtime, if config is nil, it is the same as NewEngineContext.
+func NewEngineWithConfig(ctx context.Context, arch Arch, mode Mode, config *EngineConfig) (*Engine, error) {
+	err := checkTarget(arch, mode)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process wasm module import: %s", err)
+		return nil, err
 	}
-	mod, err := runtime.Instantiate(ctx, module)
+	m, err := defaultModule()
 	if err != nil {
-		return nil, fmt.Errorf("failed to instantiate wasm module: %s", err)
+		return nil, err
 	}
-	// initialize keystone engine
+	return newEngineWithModule(ctx, m, arch, mode, config, true)
+}
+
+// NewEngineWithModule is like NewEngine but use the keystone wasm module
+// instead of the embedded module, like a build with extra LLVM targets.
+// The module can be built in different way, the function names are
+// resolved from the names in module, the "name" custom section or the
+// function signatures. The module is compiled once for the same content,
+// and it is released when all the engines that use it are closed.
+func NewEngineWithModule(bin []byte, arch Arch, mode Mode) (*Engine, error) {
+	err := checkTarget(arch, mode)
+	if err != nil {
+		return nil, err
+	}
+	m := newWasmModule(bin)
+	return newEngineWithModule(context.Background(), m, arch, mode, nil, false)
+}
+
+// newEngineWithModule is used to load the compiled module and create engine,
+// the compiled default module is pinned because it is used repeatedly.
+func newEngineWithModule(ctx context.Context, m *wasmModule, arch Arch, mode Mode, config *EngineConfig, pin bool) (*Engine, error) {
+	var cfg EngineConfig
+	if config != nil {
+		cfg = *config
+	}
+	c, err := loadCompiled(cfg, m, pin)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := newEngine(ctx, c, arch, mode)
+	if err != nil {
+		_ = c.release()
+		return nil, err
+	}
+	return engine, nil
+}
+
+// newEngine is used to instantiate the compiled module and open keystone engine.
+func newEngine(ctx context.Context, c *compiled, arch Arch, mode Mode) (*Engine, error) {
 	engine := Engine{
 		arch: arch,
 		mode: mode,
 
-		context: ctx,
-		runtime: runtime,
-		module:  mod,
-		memory:  mod.Memory(),
+		id:       engineID.Add(1),
+		context:  context.Background(),
+		compiled: c,
 
-		_malloc: mod.ExportedFunction(_malloc),
-		_free:   mod.ExportedFunction(_free),
+		calls: make(map[string]uint64),
+	}
+	err := engine.instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &engine, nil
+}
 
-		_ksOpen:     mod.ExportedFunction(_ks_open),
-		_ksOption:   mod.ExportedFunction(_ks_option),
-		_ksAsm:      mod.ExportedFunction(_ks_asm),
-		_ksFree:     mod.ExportedFunction(_ks_free),
-		_ksClose:    mod.ExportedFunction(_ks_close),
-		_ksErrno:    mod.ExportedFunction(_ks_errno),
-		_ksStrerror: mod.ExportedFunction(_ks_strerror),
-		_ksVersion:  mod.ExportedFunction(_ks_version),
+// instantiate is used to create the module instance and initialize keystone,
+// it is also used for recover the engine after the wasm call traps.
+func (e *Engine) instantiate(ctx context.Context) error {
+	c := e.compiled
+	name := fmt.Sprintf("%s_%d", keystoneModule, e.id)
+	config := wazero.NewModuleConfig().WithName(name)
+	mod, err := c.runtime.InstantiateModule(ctx, c.module, config)
+	if err != nil {
+		return fmt.Errorf("failed to instantiate wasm module: %s", err)
 	}
-	err = engine.initialize()
+	// if failed to initialize engine, close the module instance
+	var ok bool
+	defer func() {
+		if !ok {
+			_ = mod.Close(context.Background())
+		}
+	}()
+	export := func(name string) api.Function {
+		export, ok := c.funcs.Exports[name]
+		if !ok {
+			return nil
+		}
+		return mod.ExportedFunction(export)
+	}
+	e.module = mod
+	e.memory = mod.Memory()
+	e.liveAllocs = 0
+	e.arena = arena{}
+
+	e._malloc = export("malloc")
+	e._free = export("free")
+
+	e._ksOpen = export("ks_open")
+	e._ksOption = export("ks_option")
+	e._ksAsm = export("ks_asm")
+	e._ksFree = export("ks_free")
+	e._ksClose = export("ks_close")
+	e._ksErrno = export("ks_errno")
+	e._ksStrerror = export("ks_strerror")
+	e._ksVersion = export("ks_version")
+
+	e._ksArchSupported = export("ks_arch_supported")
+
+	// initialize keystone engine
+	err = e.initialize(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize keystone engine: %s", err)
+		return fmt.Errorf("failed to initialize keystone engine: %w", err)
 	}
 	ok = true
-	return &engine, nil
+	return nil
 }
 
 // processImport is used to create a module with padding
 // functions for call runtime.InstantiateModule.
-func processImport(runtime wazero.Runtime) error {
-	builder := runtime.NewHostModuleBuilder(importModule)
+func processImport(ctx context.Context, runtime wazero.Runtime, funcs *wasm.FuncMap) error {
+	builder := runtime.NewHostModuleBuilder(funcs.ImportModule)
 	fb := builder.NewFunctionBuilder()
 
-	padFn1 := func(int32, int32, int32) {
-	}
-	fb.WithFunc(padFn1).Export(___cxa_throw)
+	fb.WithFunc(cxaThrow).Export(funcs.Imports["__cxa_throw"])
 
 	padFn2 := func(int32, int32) int32 {
 		return 0
 	}
-	fb.WithFunc(padFn2).Export(___syscall_fstat64)
+	fb.WithFunc(padFn2).Export(funcs.Imports["__syscall_fstat64"])
 
 	padFn3 := func(buf int32, size int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn3).Export(___syscall_getcwd)
+	fb.WithFunc(padFn3).Export(funcs.Imports["__syscall_getcwd"])
 
 	padFn4 := func(int32, int32) int32 {
 		return 0
 	}
-	fb.WithFunc(padFn4).Export(___syscall_lstat64)
+	fb.WithFunc(padFn4).Export(funcs.Imports["__syscall_lstat64"])
 
 	padFn5 := func(int32, int32, int32, int32) int32 {
 		return 0
 	}
-	fb.WithFunc(padFn5).Export(___syscall_newfstatat)
+	fb.WithFunc(padFn5).Export(funcs.Imports["__syscall_newfstatat"])
 
 	padFn6 := func(int32, int32, int32, int32) int32 {
 		return 0
 	}
-	fb.WithFunc(padFn6).Export(___syscall_openat)
+	fb.WithFunc(padFn6).Export(funcs.Imports["__syscall_openat"])
 
 	padFn7 := func(int32, int32) int32 {
 		return 0
 	}
-	fb.WithFunc(padFn7).Export(___syscall_stat64)
+	fb.WithFunc(padFn7).Export(funcs.Imports["__syscall_stat64"])
 
-	padFn8 := func() {
-	}
-	fb.WithFunc(padFn8).Export(__abort_js)
+	fb.WithFunc(abortJS).Export(funcs.Imports["_abort_js"])
 
 	padFn9 := func(int32, int32, int32, int32, int64, int32, int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn9).Export(__mmap_js)
+	fb.WithFunc(padFn9).Export(funcs.Imports["_mmap_js"])
 
 	padFn10 := func(int32, int32, int32, int32, int32, int64) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn10).Export(__munmap_js)
+	fb.WithFunc(padFn10).Export(funcs.Imports["_munmap_js"])
 
 	padFn11 := func(v int32) int32 {
 		return 0
 	}
-	fb.WithFunc(padFn11).Export(_emscripten_resize_heap)
+	fb.WithFunc(padFn11).Export(funcs.Imports["emscripten_resize_heap"])
 
 	padFn12 := func(int32, int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn12).Export(_environ_get)
+	fb.WithFunc(padFn12).Export(funcs.Imports["environ_get"])
 
 	padFn13 := func(int32, int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn13).Export(_environ_sizes_get)
+	fb.WithFunc(padFn13).Export(funcs.Imports["environ_sizes_get"])
 
-	padFn14 := func(int32) {
-	}
-	fb.WithFunc(padFn14).Export(_exit)
+	fb.WithFunc(exit).Export(funcs.Imports["exit"])
 
 	padFn15 := func(int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn15).Export(_fd_close)
+	fb.WithFunc(padFn15).Export(funcs.Imports["fd_close"])
 
 	padFn16 := func(int32, int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn16).Export(_fd_fdstat_get)
+	fb.WithFunc(padFn16).Export(funcs.Imports["fd_fdstat_get"])
 
 	padFn17 := func(int32, int32, int32, int64, int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn17).Export(_fd_pread)
+	fb.WithFunc(padFn17).Export(funcs.Imports["fd_pread"])
 
 	padFn18 := func(int32, int32, int32, int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn18).Export(_fd_read)
+	fb.WithFunc(padFn18).Export(funcs.Imports["fd_read"])
 
 	padFn19 := func(int32, int64, int32, int32) int32 {
 		return 1
 	}
-	fb.WithFunc(padFn19).Export(_fd_seek)
+	fb.WithFunc(padFn19).Export(funcs.Imports["fd_seek"])
 
-	padFn20 := func(int32, int32, int32, int32) int32 {
-		return 1
-	}
-	fb.WithFunc(padFn20).Export(_fd_write)
+	fb.WithFunc(fdWrite).Export(funcs.Imports["fd_write"])
 
-	_, err := builder.Instantiate(context.Background())
+	_, err := builder.Instantiate(ctx)
 	return err
 }
 
-func (e *Engine) malloc(n uint32) uint32 {
-	rets, err := e._malloc.Call(e.context, uint64(n))
+// call is used to call the exported function and count it for Stats.
+func (e *Engine) call(ctx context.Context, name string, fn api.Function, params ...uint64) ([]uint64, error) {
+	e.calls[name]++
+	return fn.Call(ctx, params...)
+}
+
+func (e *Engine) malloc(n uint32) (uint32, error) {
+	rets, err := e.call(e.context, "malloc", e._malloc, uint64(n))
 	if err != nil {
-		return 0
+		return 0, e.trapped("malloc", err)
 	}
-	return uint32(rets[0])
+	ptr := uint32(rets[0])
+	if ptr == 0 {
+		return 0, fmt.Errorf("failed to allocate %d bytes in wasm module", n)
+	}
+	e.liveAllocs++
+	return ptr, nil
 }
 
-func (e *Engine) free(ptr uint32) {
-	_, err := e._malloc.Call(e.context, uint64(ptr))
+func (e *Engine) free(ptr uint32) error {
+	// the module is closed when the context is done during assembly,
+	// and the module will be discarded if the last call is trapped
+	if e.module.IsClosed() || e.trap != nil {
+		return nil
+	}
+	_, err := e.call(e.context, "free", e._free, uint64(ptr))
 	if err != nil {
-		panic(fmt.Sprintf("failed to free 0x%X: %s", ptr, err))
+		return e.trapped("free", err)
 	}
+	e.liveAllocs--
+	return nil
 }
 
-func (e *Engine) errno() uint32 {
-	rets, err := e._ksErrno.Call(e.context, e.engine)
+func (e *Engine) errno() (Error, error) {
+	rets, err := e.call(e.context, "ks_errno", e._ksErrno, e.engine)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get errno: %s", err))
+		return 0, e.trapped("ks_errno", err)
 	}
-	return uint32(rets[0])
+	return Error(rets[0]), nil
 }
 
-func (e *Engine) errnoStr(errno uint32) string {
-	rets, err := e._ksStrerror.Call(e.context, uint64(errno))
+func (e *Engine) errnoStr(errno Error) (string, error) {
+	rets, err := e.call(e.context, "ks_strerror", e._ksStrerror, uint64(errno))
 	if err != nil {
-		panic(fmt.Sprintf("failed to get errno string: %s", err))
+		return "", e.trapped("ks_strerror", err)
 	}
-	s := uint32(rets[0])
-	if s == 0 {
+	return e.readString(uint32(rets[0])), nil
+}
+
+// readString is used to read a null-terminated string from wasm memory.
+func (e *Engine) readString(ptr uint32) string {
+	if ptr == 0 {
 		return ""
 	}
-	eb := make([]byte, 0, 64)
+	s := make([]byte, 0, 64)
 	for {
-		b, _ := e.memory.ReadByte(s)
-		if b == 0x00 {
+		b, ok := e.memory.ReadByte(ptr)
+		if !ok || b == 0x00 {
 			break
 		}
-		eb = append(eb, b)
-		s++
+		s = append(s, b)
+		ptr++
+	}
+	return string(s)
+}
+
+// readUint32 is used to read a little-endian uint32 from wasm memory.
+func (e *Engine) readUint32(ptr uint32) (uint32, error) {
+	v, ok := e.memory.ReadUint32Le(ptr)
+	if !ok {
+		return 0, fmt.Errorf("failed to read wasm memory at 0x%X", ptr)
 	}
-	return string(eb)
+	return v, nil
 }
 
-func (e *Engine) initialize() error {
+// newError is used to create a KeystoneError with the error code,
+// it returns the error of ks_strerror if failed to call it.
+func (e *Engine) newError(op string, errno Error) error {
+	msg, err := e.errnoStr(errno)
+	if err != nil {
+		return err
+	}
+	ke := KeystoneError{
+		Op:      op,
+		Code:    errno,
+		Message: msg,
+	}
+	return &ke
+}
+
+func (e *Engine) initialize(ctx context.Context) error {
+	// allocate the output cells of arena, the first is used by ks_open
+	cells, err := e.malloc(3 * 4)
+	if err != nil {
+		return err
+	}
+	e.arena.cells = cells
 	// open keystone engine
-	enginePtr := e.malloc(4)
-	defer e.free(enginePtr)
-	rets, err := e._ksOpen.Call(e.context,
+	enginePtr := e.arena.cell(0)
+	rets, err := e.call(ctx, "ks_open", e._ksOpen,
 		uint64(e.arch), uint64(e.mode), uint64(enginePtr),
 	)
 	if err != nil {
@@ -256,12 +410,15 @@ func (e *Engine) initialize() error {
 	}
 	errno := Error(rets[0])
 	if errno != ERR_OK {
-		return fmt.Errorf("failed to open keystone engine: %s", e.errnoStr(errno))
+		return e.newError("ks_open", errno)
+	}
+	engine, err := e.readUint32(enginePtr)
+	if err != nil {
+		return err
 	}
-	engine, _ := e.memory.ReadUint32Le(enginePtr)
 	e.engine = uint64(engine)
 	// get keystone engine version
-	rets, err = e._ksVersion.Call(e.context, 0, 0)
+	rets, err = e.call(ctx, "ks_version", e._ksVersion, 0, 0)
 	if err != nil {
 		return fmt.Errorf("failed to call ks_version: %s", err)
 	}
@@ -274,56 +431,193 @@ func (e *Engine) initialize() error {
 
 // Option is used to set the assembly option.
 func (e *Engine) Option(typ OptionType, val OptionValue) error {
-	rets, err := e._ksOption.Call(e.context,
+	err := e.checkHealth(e.context)
+	if err != nil {
+		return err
+	}
+	err = e.option(typ, val)
+	if err != nil {
+		return err
+	}
+	e.saveOption(typ, val)
+	return nil
+}
+
+// option is used to call ks_option without check the health of engine.
+func (e *Engine) option(typ OptionType, val OptionValue) error {
+	rets, err := e.call(e.context, "ks_option", e._ksOption,
 		e.engine, uint64(typ), uint64(val),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to call ks_option: %s", err)
+		return e.trapped("ks_option", err)
 	}
 	errno := Error(rets[0])
 	if errno != ERR_OK {
-		return fmt.Errorf("failed to set keystone option: %s", e.errnoStr(errno))
+		return e.newError("ks_option", errno)
 	}
 	return nil
 }
 
+// AssembleResult contains the output and metadata of assembly.
+type AssembleResult struct {
+	// Inst is the output machine code.
+	Inst []byte
+
+	// StatCount is the number of statements that keystone processed.
+	StatCount uint32
+
+	// Address is the base address of the output.
+	Address uint64
+
+	// End is the address after the last byte of output.
+	End uint64
+
+	// Warnings is the output lines that LLVM writes to
+	// stdout and stderr during assembly, like warnings.
+	Warnings []string
+}
+
 // Assemble is used to assemble input source code.
 func (e *Engine) Assemble(src string, addr uint64) ([]byte, error) {
-	// allocate memory and write source code
-	src += "\x00"
-	srcPtr := e.malloc(uint32(len(src)))
-	defer e.free(srcPtr)
-	e.memory.WriteString(srcPtr, src)
-	// allocate memory for store pointer to output instruction
-	instAddr := e.malloc(4)
-	defer e.free(instAddr)
-	instSize := e.malloc(4)
-	defer e.free(instSize)
-	statCount := e.malloc(4)
-	defer e.free(statCount)
-	// assemble input source code
-	rets, err := e._ksAsm.Call(e.context,
+	return e.AssembleContext(e.context, src, addr)
+}
+
+// AssembleContext is used to assemble input source code with context, if the
+// context is done during assembly, it will return a *CanceledError and the
+// engine is recovered at the next call. The returned *KeystoneError not
+// contains the location of error, use AssembleResult for it.
+func (e *Engine) AssembleContext(ctx context.Context, src string, addr uint64) ([]byte, error) {
+	result, err := e.assemble(ctx, src, addr)
+	if err != nil {
+		return nil, err
+	}
+	return result.Inst, nil
+}
+
+// AssembleResult is used to assemble input source code and return the metadata.
+// If failed to assemble, the result is also returned with the statement count
+// that keystone reported, it can be used to find where the assembly stopped.
+func (e *Engine) AssembleResult(src string, addr uint64) (*AssembleResult, error) {
+	return e.AssembleResultContext(e.context, src, addr)
+}
+
+// AssembleResultContext is the context version of AssembleResult.
+// If failed to assemble, the returned *KeystoneError contains the
+// location of the statement that caused the error.
+func (e *Engine) AssembleResultContext(ctx context.Context, src string, addr uint64) (*AssembleResult, error) {
+	result, err := e.assemble(ctx, src, addr)
+	if ke := asmError(result, err); ke != nil {
+		e.locate(ctx, src, addr, result.StatCount, ke)
+	}
+	return result, err
+}
+
+// asmError returns the *KeystoneError that returned by ks_asm, it is nil if
+// the error is caused by others like failed to recover engine.
+func asmError(result *AssembleResult, err error) *KeystoneError {
+	var ke *KeystoneError
+	if result == nil || !errors.As(err, &ke) || ke.Op != "ks_asm" {
+		return nil
+	}
+	return ke
+}
+
+// assemble is used to call ks_asm without locate the error.
+func (e *Engine) assemble(ctx context.Context, src string, addr uint64) (*AssembleResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &CanceledError{Err: err}
+	}
+	if err := e.checkHealth(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() {
+		e.assembleTime += time.Since(start)
+	}()
+	// write source code to the arena and reset the output cells
+	srcPtr, err := e.writeSource(src)
+	if err != nil {
+		return nil, err
+	}
+	instAddr, instSize, statCount := e.arena.cell(0), e.arena.cell(1), e.arena.cell(2)
+	for _, cell := range []uint32{instAddr, instSize, statCount} {
+		e.memory.WriteUint32Le(cell, 0)
+	}
+	// assemble input source code and capture the output of LLVM
+	callCtx, output := withOutput(ctx)
+	rets, err := e.call(callCtx, "ks_asm", e._ksAsm,
 		e.engine, uint64(srcPtr), addr,
 		uint64(instAddr), uint64(instSize), uint64(statCount),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call ks_asm: %s", err)
+		if ctx.Err() != nil {
+			// the wasm module is closed by wazero, recover it at the next call
+			ce := &CanceledError{Err: ctx.Err()}
+			e.trap = ce
+			return nil, ce
+		}
+		return nil, e.trapped("ks_asm", err)
 	}
-	errno := Error(rets[0])
-	if errno != ERR_OK {
-		return nil, fmt.Errorf("failed to assemble: %s", e.errnoStr(e.errno()))
+	count, err := e.readUint32(statCount)
+	if err != nil {
+		e.trap = err
+		return nil, err
+	}
+	result := AssembleResult{
+		StatCount: count,
+		Address:   addr,
+		End:       addr,
+		Warnings:  outputLines(output),
+	}
+	if Error(rets[0]) != ERR_OK {
+		errno, err := e.errno()
+		if err != nil {
+			return nil, err
+		}
+		err = e.newError("ks_asm", errno)
+		var ke *KeystoneError
+		if errors.As(err, &ke) {
+			ke.Warnings = result.Warnings
+			return &result, ke
+		}
+		return nil, err
 	}
 	// copy output instruction to host memory
-	instPtr, _ := e.memory.ReadUint32Le(instAddr)
-	instLen, _ := e.memory.ReadUint32Le(instSize)
-	inst, _ := e.memory.Read(instPtr, instLen)
-	inst = bytes.Clone(inst)
+	// the state of keystone is unknown if the output is out of range,
+	// so the engine will be recovered at the next call
+	instPtr, err := e.readUint32(instAddr)
+	if err != nil {
+		e.trap = err
+		return nil, err
+	}
+	instLen, err := e.readUint32(instSize)
+	if err != nil {
+		e.trap = err
+		return nil, err
+	}
+	inst, ok := e.memory.Read(instPtr, instLen)
+	if !ok {
+		err = fmt.Errorf("failed to read %d bytes of instruction at 0x%X from wasm memory", instLen, instPtr)
+		e.trap = err
+		return nil, err
+	}
+	result.Inst = bytes.Clone(inst)
+	result.End = addr + uint64(len(result.Inst))
 	// free output instruction memory
-	_, err = e._ksFree.Call(e.context, uint64(instPtr))
+	_, err = e.call(e.context, "ks_free", e._ksFree, uint64(instPtr))
 	if err != nil {
-		return nil, fmt.Errorf("failed to call ks_free: %s", err)
+		return nil, e.trapped("ks_free", err)
+	}
+	return &result, nil
+}
+
+// release is used to release the compiled module once when close engine.
+func (e *Engine) release() {
+	if e.released {
+		return
 	}
-	return inst, nil
+	e.released = true
+	_ = e.compiled.release()
 }
 
 // Version is used to get the keystone engine version.
@@ -331,26 +625,34 @@ func (e *Engine) Version() string {
 	return e.version
 }
 
-// Close is used to close keystone engine and wasm runtime.
+// Close is used to close keystone engine and wasm module instance.
 func (e *Engine) Close() error {
-	// close keystone engine
-	rets, err := e._ksClose.Call(e.context, e.engine)
-	if err != nil {
-		return fmt.Errorf("failed to call ks_close: %s", err)
+	// the wasm module is closed when the context is done during assembly,
+	// and the state of keystone is corrupted if the last call is trapped,
+	// the modules are closed even if failed to close keystone engine
+	e.closed = true
+	defer e.release()
+	var closeErr error
+	if !e.module.IsClosed() && e.trap == nil {
+		// close keystone engine
+		rets, err := e.call(e.context, "ks_close", e._ksClose, e.engine)
+		if err != nil {
+			closeErr = fmt.Errorf("failed to call ks_close: %s", err)
+		} else if errno := Error(rets[0]); errno != ERR_OK {
+			closeErr = e.newError("ks_close", errno)
+		}
 	}
-	errno := Error(rets[0])
-	if errno != ERR_OK {
-		return fmt.Errorf("failed to close keystone engine: %s", e.errnoStr(errno))
+	// close the modules about symbol resolver
+	for _, mod := range e.resolverMods {
+		err := mod.Close(e.context)
+		if err != nil {
+			return fmt.Errorf("failed to close resolver module: %s", err)
+		}
 	}
 	// close wasm module
-	err = e.module.Close(e.context)
+	err := e.module.Close(e.context)
 	if err != nil {
 		return fmt.Errorf("failed to close wasm module: %s", err)
 	}
-	// close wasm runtime
-	err = e.runtime.Close(e.context)
-	if err != nil {
-		return fmt.Errorf("failed to close wasm runtime: %s", err)
-	}
-	return nil
+	return closeErr
 }
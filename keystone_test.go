@@ -2,8 +2,12 @@ package keystone
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -42,6 +46,60 @@ func TestEngine(t *testing.T) {
 	})
 }
 
+func TestEngine_SharedModule(t *testing.T) {
+	engine1, err := NewEngine(ARCH_X86, MODE_32)
+	require.NoError(t, err)
+	engine2, err := NewEngine(ARCH_X86, MODE_64)
+	require.NoError(t, err)
+
+	inst, err := engine1.Assemble("xor eax, eax\n", 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x31, 0xC0}, inst)
+
+	err = engine1.Close()
+	require.NoError(t, err)
+
+	inst, err = engine2.Assemble("xor rax, rax\n", 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x48, 0x31, 0xC0}, inst)
+
+	err = engine2.Close()
+	require.NoError(t, err)
+}
+
+func TestNewEngineWithConfig(t *testing.T) {
+	src := strings.Repeat("xor rax, rax\nmov rbx, [rsp+0x10]\ncall label\nlabel:\nret\n", 1000)
+
+	assemble := func(t *testing.T, config *EngineConfig) []byte {
+		engine, err := NewEngineWithConfig(context.Background(), ARCH_X86, MODE_64, config)
+		require.NoError(t, err)
+		inst, err := engine.Assemble(src, 0x1000)
+		require.NoError(t, err)
+		err = engine.Close()
+		require.NoError(t, err)
+		return inst
+	}
+
+	expected := assemble(t, nil)
+	require.NotEmpty(t, expected)
+
+	t.Run("interpreter", func(t *testing.T) {
+		inst := assemble(t, &EngineConfig{})
+		require.Equal(t, expected, inst)
+	})
+
+	t.Run("compiler", func(t *testing.T) {
+		inst := assemble(t, &EngineConfig{Compiler: true})
+		require.Equal(t, expected, inst)
+	})
+
+	t.Run("compiler with cache", func(t *testing.T) {
+		dir := t.TempDir()
+		inst := assemble(t, &EngineConfig{Compiler: true, CacheDir: dir})
+		require.Equal(t, expected, inst)
+	})
+}
+
 func TestEngine_Option(t *testing.T) {
 	engine, err := NewEngine(ARCH_X86, MODE_32)
 	require.NoError(t, err)
@@ -55,6 +113,7 @@ func TestEngine_Option(t *testing.T) {
 		err = engine.Option(123, OPT_SYNTAX_INTEL)
 		errStr := "failed to set keystone option: Invalid option (KS_ERR_OPT_INVALID)"
 		require.EqualError(t, err, errStr)
+		require.ErrorIs(t, err, ErrOptInvalid)
 	})
 
 	t.Run("invalid option value", func(t *testing.T) {
@@ -117,9 +176,147 @@ func TestEngine_Assemble(t *testing.T) {
 		require.EqualError(t, err, errStr)
 		require.Nil(t, inst)
 
+		require.ErrorIs(t, err, ErrMnemonicFail)
+		require.NotErrorIs(t, err, ErrSymbolMissing)
+		var ke *KeystoneError
+		require.True(t, errors.As(err, &ke))
+		require.Equal(t, "ks_asm", ke.Op)
+		require.Equal(t, ERR_ASM_MNEMONICFAIL, ke.Code)
+		require.Equal(t, "Invalid mnemonic (KS_ERR_ASM_MNEMONICFAIL)", ke.Message)
+		require.Zero(t, ke.Line)
+
+		// the error is located by AssembleResult
+		_, err = engine.AssembleResult(src, 0)
+		errStr = "failed to assemble at line 1, column 1: Invalid mnemonic (KS_ERR_ASM_MNEMONICFAIL)"
+		require.EqualError(t, err, errStr)
+		require.True(t, errors.As(err, &ke))
+		require.Equal(t, 1, ke.Line)
+		require.Equal(t, 1, ke.Column)
+		require.Equal(t, "invalid", ke.Text)
+
+		err = engine.Close()
+		require.NoError(t, err)
+	})
+}
+
+func TestEngine_AssembleResult(t *testing.T) {
+	engine, err := NewEngine(ARCH_X86, MODE_32)
+	require.NoError(t, err)
+
+	t.Run("common", func(t *testing.T) {
+		src := "xor eax, eax\ninc eax\nret\n"
+		result, err := engine.AssembleResult(src, 0x1000)
+		require.NoError(t, err)
+
+		expected := []byte{0x31, 0xC0, 0x40, 0xC3}
+		require.Equal(t, expected, result.Inst)
+		require.Equal(t, uint32(3), result.StatCount)
+		require.Equal(t, uint64(0x1000), result.Address)
+		require.Equal(t, uint64(0x1004), result.End)
+	})
+
+	t.Run("invalid source", func(t *testing.T) {
+		src := "xor eax, eax\ninvalid\nret\n"
+		result, err := engine.AssembleResult(src, 0x1000)
+		require.ErrorIs(t, err, ErrMnemonicFail)
+		require.NotNil(t, result)
+		require.Nil(t, result.Inst)
+		require.Equal(t, uint64(0x1000), result.Address)
+		require.Equal(t, uint64(0x1000), result.End)
+	})
+
+	err = engine.Close()
+	require.NoError(t, err)
+}
+
+func TestEngine_AssembleContext(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		engine, err := NewEngineContext(context.Background(), ARCH_X86, MODE_32)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		inst, err := engine.AssembleContext(ctx, "xor eax, eax\nret\n", 0)
+		require.NoError(t, err)
+		expected := []byte{0x31, 0xC0, 0xC3}
+		require.Equal(t, expected, inst)
+
+		err = engine.Close()
+		require.NoError(t, err)
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		engine, err := NewEngine(ARCH_X86, MODE_32)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		src := ".rept 100000000\nnop\n.endr\n"
+		inst, err := engine.AssembleContext(ctx, src, 0)
+		var ce *CanceledError
+		require.True(t, errors.As(err, &ce))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Nil(t, inst)
+
+		// the engine is recovered at the next call
+		inst, err = engine.Assemble("xor eax, eax\nret\n", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x31, 0xC0, 0xC3}, inst)
+
 		err = engine.Close()
 		require.NoError(t, err)
 	})
+
+	t.Run("canceled before assemble", func(t *testing.T) {
+		engine, err := NewEngine(ARCH_X86, MODE_32)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		inst, err := engine.AssembleContext(ctx, "nop\n", 0)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Nil(t, inst)
+
+		err = engine.Close()
+		require.NoError(t, err)
+	})
+}
+
+func TestEngine_ErrorLocation(t *testing.T) {
+	engine, err := NewEngine(ARCH_X86, MODE_32)
+	require.NoError(t, err)
+
+	t.Run("invalid mnemonic", func(t *testing.T) {
+		src := strings.Repeat("xor eax, eax\n", 100)
+		src += "  jmp end\n"
+		src += "\tinvalid eax\n"
+		src += "end:\n"
+		src += "ret\n"
+		_, err := engine.AssembleResult(src, 0)
+		var ke *KeystoneError
+		require.True(t, errors.As(err, &ke))
+		require.Equal(t, ERR_ASM_MNEMONICFAIL, ke.Code)
+		require.Equal(t, 102, ke.Line)
+		require.Equal(t, 2, ke.Column)
+		require.Equal(t, "invalid eax", ke.Text)
+	})
+
+	t.Run("symbol missing", func(t *testing.T) {
+		src := "xor eax, eax\n"
+		src += "jmp forward\n"
+		src += "jmp missing\n"
+		src += "forward:\n"
+		src += "ret\n"
+		_, err := engine.AssembleResult(src, 0)
+		var ke *KeystoneError
+		require.True(t, errors.As(err, &ke))
+		require.Equal(t, ERR_ASM_SYMBOL_MISSING, ke.Code)
+		require.Equal(t, 3, ke.Line)
+		require.Equal(t, "jmp missing", ke.Text)
+	})
+
+	err = engine.Close()
+	require.NoError(t, err)
 }
 
 func TestEngine_Version(t *testing.T) {
@@ -132,3 +329,124 @@ func TestEngine_Version(t *testing.T) {
 	err = engine.Close()
 	require.NoError(t, err)
 }
+
+func TestEngine_SetSymbolResolver(t *testing.T) {
+	engine, err := NewEngine(ARCH_X86, MODE_64)
+	require.NoError(t, err)
+
+	t.Run("common", func(t *testing.T) {
+		err = engine.SetSymbolResolver(func(symbol string) (uint64, bool) {
+			if symbol == "target" {
+				return 0x1000, true
+			}
+			return 0, false
+		})
+		require.NoError(t, err)
+
+		inst, err := engine.Assemble("jmp target\n", 0)
+		require.NoError(t, err)
+		expected := []byte{0xE9, 0xFB, 0x0F, 0x00, 0x00}
+		require.Equal(t, expected, inst)
+	})
+
+	t.Run("unknown symbol", func(t *testing.T) {
+		inst, err := engine.Assemble("jmp unknown\n", 0)
+		require.ErrorIs(t, err, ErrSymbolMissing)
+		require.Nil(t, inst)
+	})
+
+	t.Run("remove resolver", func(t *testing.T) {
+		err = engine.SetSymbolResolver(nil)
+		require.NoError(t, err)
+
+		inst, err := engine.Assemble("jmp target\n", 0)
+		require.ErrorIs(t, err, ErrSymbolMissing)
+		require.Nil(t, inst)
+	})
+
+	err = engine.Close()
+	require.NoError(t, err)
+}
+
+func BenchmarkNewEngine(b *testing.B) {
+	b.Run("shared module", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			engine, err := NewEngine(ARCH_X86, MODE_64)
+			require.NoError(b, err)
+			err = engine.Close()
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("compile module", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m, err := defaultModule()
+			require.NoError(b, err)
+			c, err := compileModule(EngineConfig{}, m)
+			require.NoError(b, err)
+			engine, err := newEngine(context.Background(), c, ARCH_X86, MODE_64)
+			require.NoError(b, err)
+			err = engine.Close()
+			require.NoError(b, err)
+			err = c.close()
+			require.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkEngine_Assemble(b *testing.B) {
+	engine, err := NewEngine(ARCH_X86, MODE_64)
+	require.NoError(b, err)
+	defer func() { require.NoError(b, engine.Close()) }()
+
+	b.Run("tiny", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := engine.Assemble("nop", 0)
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("large", func(b *testing.B) {
+		src := strings.Builder{}
+		for i := 0; i < 10000; i++ {
+			fmt.Fprintf(&src, "mov rax, %d\nlea rbx, [rax+%d]\n", i, i%4096)
+		}
+		b.SetBytes(int64(src.Len()))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := engine.Assemble(src.String(), 0)
+			require.NoError(b, err)
+		}
+	})
+}
+
+func TestKeystoneError(t *testing.T) {
+	err := error(&KeystoneError{
+		Op:      "ks_asm",
+		Code:    ERR_ASM_SYMBOL_MISSING,
+		Message: "Cannot find a symbol (KS_ERR_ASM_SYMBOL_MISSING)",
+	})
+	require.EqualError(t, err, "failed to assemble: Cannot find a symbol (KS_ERR_ASM_SYMBOL_MISSING)")
+
+	wrapped := fmt.Errorf("wrapped: %w", err)
+	require.ErrorIs(t, wrapped, ErrSymbolMissing)
+	require.ErrorIs(t, wrapped, &KeystoneError{Op: "ks_asm", Code: ERR_ASM_SYMBOL_MISSING})
+	require.NotErrorIs(t, wrapped, &KeystoneError{Op: "ks_option", Code: ERR_ASM_SYMBOL_MISSING})
+	require.NotErrorIs(t, wrapped, ErrSymbolRedefined)
+
+	t.Run("with warnings", func(t *testing.T) {
+		err := &KeystoneError{
+			Op:       "ks_asm",
+			Code:     ERR_ASM_INVALIDOPERAND,
+			Message:  "Invalid operand (KS_ERR_ASM_INVALIDOPERAND)",
+			Line:     2,
+			Column:   5,
+			Warnings: []string{"<stdin>:2:5: warning: scale factor without index register is ignored"},
+		}
+		require.EqualError(t, err, "failed to assemble at line 2, column 5: Invalid operand (KS_ERR_ASM_INVALIDOPERAND)\n"+
+			"    <stdin>:2:5: warning: scale factor without index register is ignored")
+	})
+}
@@ -0,0 +1,261 @@
+package keystone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxListingPass is the max number of passes for resolve the label address.
+const maxListingPass = 8
+
+// ListingLine is a source statement in the assembly listing.
+type ListingLine struct {
+	// Line is the line number in source code, start from 1.
+	Line int
+
+	// Text is the source code of this line.
+	Text string
+
+	// Address is the address of the first byte of this line.
+	Address uint64
+
+	// Inst is the machine code of this line, it is empty for labels,
+	// comments and directives that not generate any output.
+	Inst []byte
+}
+
+// Listing is used to assemble input source code and return the listing
+// that contains the address and machine code of each non-empty line.
+//
+// Keystone can not report the offset of each statement, so each statement
+// is assembled alone at its address, and the labels are resolved with the
+// address from previous pass. If the output of statements is not the same
+// as the source code assembled at once, like the size of instruction depends
+// on the whole source code, the listing is built by assemble the prefix of
+// the source code, it is slow for the large source code.
+func (e *Engine) Listing(src string, addr uint64) ([]*ListingLine, error) {
+	result, err := e.AssembleResult(src, addr)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(src)
+	ends, insts, err := e.listingEnds(lines, addr, e.statementPass)
+	if err != nil {
+		return nil, err
+	}
+	listing, err := buildListing(lines, addr, ends, insts, result.Inst)
+	if err == nil {
+		return listing, nil
+	}
+	ends, _, err = e.listingEnds(lines, addr, e.prefixPass)
+	if err != nil {
+		return nil, err
+	}
+	return buildListing(lines, addr, ends, nil, result.Inst)
+}
+
+// listingPass returns the end address of each line and the output of the
+// lines that assembled alone, the output is nil if the line is not.
+type listingPass func(lines []string, addr uint64) ([]uint64, [][]byte, error)
+
+// listingEnds is used to run the pass until the address of labels are not
+// changed, the labels are resolved with the address from previous pass.
+func (e *Engine) listingEnds(lines []string, addr uint64, pass listingPass) ([]uint64, [][]byte, error) {
+	labelLine := findLabels(lines)
+	if len(labelLine) == 0 {
+		return pass(lines, addr)
+	}
+	labels := make(map[string]uint64, len(labelLine))
+	for _, label := range labelLine {
+		labels[label] = addr
+	}
+	var (
+		ends  []uint64
+		insts [][]byte
+	)
+	err := e.withLabels(labels, func() error {
+		var err error
+		for i := 0; i < maxListingPass; i++ {
+			ends, insts, err = pass(lines, addr)
+			if err != nil {
+				return err
+			}
+			var changed bool
+			for i, label := range labelLine {
+				start := addr
+				if i > 0 {
+					start = ends[i-1]
+				}
+				if labels[label] != start {
+					labels[label] = start
+					changed = true
+				}
+			}
+			if !changed {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ends, insts, nil
+}
+
+// buildListing is used to build listing and check it is the same as the
+// assembled output, the insts is the output of lines that assembled alone.
+func buildListing(lines []string, addr uint64, ends []uint64, insts [][]byte, inst []byte) ([]*ListingLine, error) {
+	listing := make([]*ListingLine, 0, len(lines))
+	start := addr
+	for i, line := range lines {
+		end := ends[i]
+		if strings.TrimSpace(line) != "" {
+			if end < start || end-addr > uint64(len(inst)) {
+				return nil, errors.New("failed to build listing: invalid statement boundary")
+			}
+			out := inst[start-addr : end-addr]
+			if insts != nil && insts[i] != nil && !bytes.Equal(insts[i], out) {
+				return nil, fmt.Errorf("failed to build listing: output mismatch at line %d", i+1)
+			}
+			listing = append(listing, &ListingLine{
+				Line:    i + 1,
+				Text:    line,
+				Address: start,
+				Inst:    bytes.Clone(out),
+			})
+		}
+		start = end
+	}
+	if start-addr != uint64(len(inst)) {
+		return nil, fmt.Errorf("failed to build listing: size mismatch %d != %d", start-addr, len(inst))
+	}
+	return listing, nil
+}
+
+var (
+	// stateRe is used to match the directives that change the state of
+	// assembler without output, they are kept before each statement.
+	stateRe = regexp.MustCompile(`^\.(?:code16|code32|code64|arm|thumb|code|syntax|intel_syntax|att_syntax|` +
+		`set|equ|equiv|eqv|arch|arch_extension|cpu|fpu|option|globl|global|local|weak|` +
+		`text|data|section|type|size|file|ident|list|nolist)\b|^[A-Za-z_.$][A-Za-z0-9_.$]*\s*=[^=]`)
+
+	// blockRe is used to match the directives that can not be assembled
+	// alone, like the block and the directives depend on the previous output.
+	blockRe = regexp.MustCompile(`^\.(?:rept|irp|irpc|if\w*|else\w*|endif|endr|align|balign\w*|p2align\w*|org)\b`)
+
+	macroRe    = regexp.MustCompile(`^\.macro\b`)
+	macroEndRe = regexp.MustCompile(`^\.endm\b`)
+)
+
+// statement returns the text of line after the label.
+func statement(line string) string {
+	if m := labelRe.FindStringIndex(line); m != nil {
+		line = line[m[1]:]
+	}
+	return strings.TrimSpace(line)
+}
+
+// statementPass is used to assemble each statement alone at its address. The
+// directives that change the state of assembler like ".code64" and ".equ",
+// and the macro definitions are kept before each statement. The blocks like
+// ".rept" and the directives like ".align" are assembled with the prefix of
+// the source code, if the prefix can not be assembled, like in the middle of
+// block, the output is counted to the next line.
+func (e *Engine) statementPass(lines []string, addr uint64) ([]uint64, [][]byte, error) {
+	ends := make([]uint64, len(lines))
+	insts := make([][]byte, len(lines))
+	var (
+		state   []string
+		pending bool
+	)
+	end := addr
+	for i := 0; i < len(lines); i++ {
+		stmt := statement(lines[i])
+		switch {
+		case strings.TrimSpace(lines[i]) == "":
+			ends[i] = end
+			continue
+		case macroRe.MatchString(stmt):
+			// keep the macro definition for the invocations
+			depth := 0
+			for ; i < len(lines); i++ {
+				stmt = statement(lines[i])
+				state = append(state, lines[i])
+				ends[i] = end
+				if macroRe.MatchString(stmt) {
+					depth++
+				} else if macroEndRe.MatchString(stmt) {
+					depth--
+				}
+				if depth == 0 {
+					break
+				}
+			}
+			continue
+		case stateRe.MatchString(stmt):
+			state = append(state, lines[i])
+			ends[i] = end
+			continue
+		case pending || blockRe.MatchString(stmt):
+		case stmt == "":
+			// the line only has label
+			ends[i] = end
+			continue
+		default:
+			src := strings.Join(append(state[:len(state):len(state)], lines[i]), "\n")
+			result, err := e.assemble(e.context, src, end)
+			var ke *KeystoneError
+			switch {
+			case err == nil:
+				end = result.End
+				ends[i] = end
+				insts[i] = result.Inst
+				continue
+			case !errors.As(err, &ke):
+				return nil, nil, err
+			}
+		}
+		// assemble with the prefix of source code
+		prefix := strings.Join(lines[:i+1], "\n")
+		result, err := e.assemble(e.context, prefix, addr)
+		var ke *KeystoneError
+		switch {
+		case err == nil:
+			end = result.End
+			pending = false
+		case errors.As(err, &ke):
+			pending = true
+		default:
+			return nil, nil, err
+		}
+		ends[i] = end
+	}
+	return ends, insts, nil
+}
+
+// prefixPass is used to assemble each prefix of the source code and return
+// the end address of each line. If the prefix can not be assembled, like in
+// the middle of macro, the output is counted to the next line.
+func (e *Engine) prefixPass(lines []string, addr uint64) ([]uint64, [][]byte, error) {
+	ends := make([]uint64, len(lines))
+	end := addr
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			prefix := strings.Join(lines[:i+1], "\n")
+			result, err := e.assemble(e.context, prefix, addr)
+			var ke *KeystoneError
+			switch {
+			case err == nil:
+				end = result.End
+			case !errors.As(err, &ke):
+				return nil, nil, err
+			}
+		}
+		ends[i] = end
+	}
+	return ends, nil, nil
+}
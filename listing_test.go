@@ -0,0 +1,117 @@
+package keystone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Listing(t *testing.T) {
+	engine, err := NewEngine(ARCH_X86, MODE_32)
+	require.NoError(t, err)
+
+	t.Run("common", func(t *testing.T) {
+		src := "start:\n"
+		src += "  xor eax, eax\n"
+		src += "\n"
+		src += "  jmp end\n"
+		src += "  nop\n"
+		src += "end:\n"
+		src += "  jmp start\n"
+
+		listing, err := engine.Listing(src, 0x1000)
+		require.NoError(t, err)
+
+		expected := []*ListingLine{
+			{Line: 1, Text: "start:", Address: 0x1000, Inst: []byte{}},
+			{Line: 2, Text: "  xor eax, eax", Address: 0x1000, Inst: []byte{0x31, 0xC0}},
+			{Line: 4, Text: "  jmp end", Address: 0x1002, Inst: []byte{0xEB, 0x01}},
+			{Line: 5, Text: "  nop", Address: 0x1004, Inst: []byte{0x90}},
+			{Line: 6, Text: "end:", Address: 0x1005, Inst: []byte{}},
+			{Line: 7, Text: "  jmp start", Address: 0x1005, Inst: []byte{0xEB, 0xF9}},
+		}
+		require.Equal(t, expected, listing)
+	})
+
+	t.Run("with symbol resolver", func(t *testing.T) {
+		err = engine.SetSymbolResolver(func(symbol string) (uint64, bool) {
+			return 0x2000, symbol == "external"
+		})
+		require.NoError(t, err)
+
+		src := "jmp next\nnext:\njmp external\n"
+		listing, err := engine.Listing(src, 0x1000)
+		require.NoError(t, err)
+		require.Len(t, listing, 3)
+		require.Equal(t, []byte{0xEB, 0x00}, listing[0].Inst)
+		require.Equal(t, uint64(0x1002), listing[2].Address)
+
+		// the resolver is restored after listing
+		inst, err := engine.Assemble("jmp external\n", 0x1002)
+		require.NoError(t, err)
+		require.Equal(t, listing[2].Inst, inst)
+
+		err = engine.SetSymbolResolver(nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid source", func(t *testing.T) {
+		listing, err := engine.Listing("xor eax, eax\ninvalid\n", 0)
+		require.ErrorIs(t, err, ErrMnemonicFail)
+		require.Nil(t, listing)
+	})
+
+	err = engine.Close()
+	require.NoError(t, err)
+}
+
+func TestEngine_ListingPass(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	calls := func() uint64 {
+		return engine.Stats().Calls["ks_asm"]
+	}
+
+	t.Run("statement", func(t *testing.T) {
+		before := calls()
+		listing, err := engine.Listing("n\n.code32\n\nnn\n  .org 8\nnnn\n", 0x10)
+		require.NoError(t, err)
+		expected := []*ListingLine{
+			{Line: 1, Text: "n", Address: 0x10, Inst: []byte{0xC3}},
+			{Line: 2, Text: ".code32", Address: 0x11, Inst: []byte{}},
+			{Line: 4, Text: "nn", Address: 0x11, Inst: []byte{0xC3, 0xC3}},
+			{Line: 5, Text: "  .org 8", Address: 0x13, Inst: []byte{}},
+			{Line: 6, Text: "nnn", Address: 0x13, Inst: []byte{0xC3, 0xC3, 0xC3}},
+		}
+		require.Equal(t, expected, listing)
+		// assemble once, then each statement and the prefix for ".org"
+		require.Equal(t, uint64(5), calls()-before)
+	})
+
+	t.Run("label", func(t *testing.T) {
+		listing, err := engine.Listing("l1:\nn\nl2: nn\nn", 0x10)
+		require.NoError(t, err)
+		expected := []*ListingLine{
+			{Line: 1, Text: "l1:", Address: 0x10, Inst: []byte{}},
+			{Line: 2, Text: "n", Address: 0x10, Inst: []byte{0xC3}},
+			{Line: 3, Text: "l2: nn", Address: 0x11, Inst: []byte{0xC3, 0xC3}},
+			{Line: 4, Text: "n", Address: 0x13, Inst: []byte{0xC3}},
+		}
+		require.Equal(t, expected, listing)
+	})
+
+	t.Run("fallback to prefix", func(t *testing.T) {
+		// the state directive has output in fake module, so
+		// the output of each statement is not the same
+		listing, err := engine.Listing(".fpu neon\nn\nnn", 0)
+		require.NoError(t, err)
+		expected := []*ListingLine{
+			{Line: 1, Text: ".fpu neon", Address: 0, Inst: []byte{0xC3, 0xC3}},
+			{Line: 2, Text: "n", Address: 2, Inst: []byte{0xC3}},
+			{Line: 3, Text: "nn", Address: 3, Inst: []byte{0xC3, 0xC3}},
+		}
+		require.Equal(t, expected, listing)
+	})
+}
@@ -0,0 +1,182 @@
+package keystone
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+// ModuleEnv is the environment variable of the wasm module path,
+// if it is set, NewEngine will use it instead of the embedded module.
+const ModuleEnv = "KEYSTONE_WASM"
+
+// ErrNoModule is returned when create engine without wasm module, it only
+// occurs with build tag keystone_noembed and the module is not supplied.
+var ErrNoModule = errors.New("keystone wasm module is not supplied")
+
+// wasmModule is a keystone wasm module, the compiled module
+// is shared by the wasm modules with the same hash.
+type wasmModule struct {
+	bin  []byte
+	hash [sha256.Size]byte
+
+	// release module is the same as the pinned moduleSHA256, it uses
+	// the names in func_map.go, the others are resolved from module.
+	release bool
+}
+
+func newWasmModule(bin []byte) *wasmModule {
+	hash := sha256.Sum256(bin)
+	return &wasmModule{
+		bin:     bin,
+		hash:    hash,
+		release: hex.EncodeToString(hash[:]) == moduleSHA256,
+	}
+}
+
+// embeddedModule is used to decompress the embedded module once, it
+// returns nil if it is built with tag keystone_noembed.
+var embeddedModule = sync.OnceValues(func() (*wasmModule, error) {
+	if len(compressedModule) == 0 {
+		return nil, nil
+	}
+	return decompressModule(compressedModule)
+})
+
+// decompressModule is used to decompress the module and check the hash of
+// it, the embedded module is rejected if the hash is not pinned.
+func decompressModule(data []byte) (*wasmModule, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress embedded wasm module: %s", err)
+	}
+	bin, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress embedded wasm module: %s", err)
+	}
+	if moduleSHA256 == "" {
+		return nil, errors.New("the hash of embedded wasm module is not pinned in module_hash.go")
+	}
+	m := newWasmModule(bin)
+	if !m.release {
+		return nil, fmt.Errorf("embedded wasm module is corrupted: sha256 %x, expected %s", m.hash, moduleSHA256)
+	}
+	return m, nil
+}
+
+var (
+	moduleMu sync.Mutex
+
+	// supplied is the module that set by SetModule.
+	supplied *wasmModule
+
+	envModulePath string
+	envModule     *wasmModule
+)
+
+// SetModule is used to set the keystone wasm module that used by NewEngine,
+// it is used with build tag keystone_noembed, or replace the embedded module.
+// Set nil for remove the supplied module.
+func SetModule(bin []byte) {
+	moduleMu.Lock()
+	defer moduleMu.Unlock()
+	if len(bin) == 0 {
+		supplied = nil
+		return
+	}
+	supplied = newWasmModule(bin)
+}
+
+// SetModuleFile is like SetModule but read the wasm module from file.
+func SetModuleFile(path string) error {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read wasm module: %s", err)
+	}
+	SetModule(bin)
+	return nil
+}
+
+// SetModuleFS is like SetModule but read the wasm module from file system.
+func SetModuleFS(fsys fs.FS, name string) error {
+	bin, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return fmt.Errorf("failed to read wasm module: %s", err)
+	}
+	SetModule(bin)
+	return nil
+}
+
+// defaultModule returns the wasm module that used by NewEngine, the order
+// is the supplied module, the path in ModuleEnv and the embedded module.
+func defaultModule() (*wasmModule, error) {
+	moduleMu.Lock()
+	defer moduleMu.Unlock()
+	if supplied != nil {
+		return supplied, nil
+	}
+	path := os.Getenv(ModuleEnv)
+	if path == "" {
+		m, err := embeddedModule()
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			return nil, ErrNoModule
+		}
+		return m, nil
+	}
+	if envModule != nil && envModulePath == path {
+		return envModule, nil
+	}
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm module in %s: %s", ModuleEnv, err)
+	}
+	envModulePath = path
+	envModule = newWasmModule(bin)
+	return envModule, nil
+}
+
+// funcMap returns the names of imported and exported functions and the export
+// name of function table, the names are checked with the compiled module.
+func (m *wasmModule) funcMap(cm wazero.CompiledModule) (*wasm.FuncMap, string, error) {
+	mod, err := wasm.Parse(m.bin)
+	if err != nil {
+		return nil, "", err
+	}
+	table, _ := mod.ExportName(wasm.ExternTable)
+	if m.release {
+		fm := releaseFuncMap()
+		err = checkModule(cm, fm)
+		if err != nil {
+			return nil, "", err
+		}
+		return fm, table, nil
+	}
+	fm, err := wasm.Resolve(mod, nil)
+	if err == nil {
+		err = checkModule(cm, fm)
+		if err != nil {
+			return nil, "", err
+		}
+		return fm, table, nil
+	}
+	// the names of module that is minified can not be resolved,
+	// try the names of release module, like the other version
+	if checkModule(cm, releaseFuncMap()) == nil {
+		return releaseFuncMap(), table, nil
+	}
+	return nil, "", fmt.Errorf("failed to resolve function names: %s", err)
+}
@@ -0,0 +1,74 @@
+package keystone
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+)
+
+// ModuleMetadata contains the information about the keystone wasm module.
+type ModuleMetadata struct {
+	// SHA256 is the hex encoded hash of the decompressed module.
+	SHA256 string
+
+	// Size is the size of the decompressed module.
+	Size int
+
+	// CompressedSize is the size of the embedded compressed
+	// module, it is zero if the module is not embedded.
+	CompressedSize int
+
+	// Release is true if the module is the pinned release module.
+	Release bool
+
+	// Version is the version of keystone like "0.9".
+	Version string
+
+	// Exports is the name of exported functions that sorted, the
+	// keystone functions use the C name like "ks_asm" instead of
+	// the minified name in module.
+	Exports []string
+}
+
+// ModuleInfo is used to get the information about the wasm module that
+// used by NewEngine, it will create a temporary engine for get version.
+func ModuleInfo() (*ModuleMetadata, error) {
+	m, err := defaultModule()
+	if err != nil {
+		return nil, err
+	}
+	c, err := loadCompiled(EngineConfig{}, m, true)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := newEngine(context.Background(), c, ARCH_X86, MODE_32)
+	if err != nil {
+		_ = c.release()
+		return nil, err
+	}
+	err = engine.Close()
+	if err != nil {
+		return nil, err
+	}
+	info := ModuleMetadata{
+		SHA256:  hex.EncodeToString(m.hash[:]),
+		Size:    len(m.bin),
+		Release: m.release,
+		Version: engine.Version(),
+	}
+	if e, _ := embeddedModule(); e == m {
+		info.CompressedSize = len(compressedModule)
+	}
+	names := make(map[string]string, len(c.funcs.Exports))
+	for name, export := range c.funcs.Exports {
+		names[export] = name
+	}
+	for export := range c.module.ExportedFunctions() {
+		if name, ok := names[export]; ok {
+			export = name
+		}
+		info.Exports = append(info.Exports, export)
+	}
+	sort.Strings(info.Exports)
+	return &info, nil
+}
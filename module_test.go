@@ -0,0 +1,154 @@
+package keystone
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEngineWithModule(t *testing.T) {
+	t.Run("invalid module", func(t *testing.T) {
+		engine, err := NewEngineWithModule([]byte("not a wasm module"), ARCH_X86, MODE_32)
+		require.Error(t, err)
+		require.Nil(t, engine)
+	})
+
+	t.Run("unresolved functions", func(t *testing.T) {
+		// a module only contains an empty type section
+		bin := []byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00}
+		engine, err := NewEngineWithModule(bin, ARCH_X86, MODE_32)
+		require.ErrorContains(t, err, "invalid keystone wasm module: failed to resolve function names")
+		require.Nil(t, engine)
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		engine, err := NewEngineWithModule(nil, ARCH_X86, MODE_ARM)
+		require.ErrorIs(t, err, ErrMode)
+		require.Nil(t, engine)
+	})
+}
+
+func TestModuleEnv(t *testing.T) {
+	t.Run("not exist", func(t *testing.T) {
+		t.Setenv(ModuleEnv, filepath.Join(t.TempDir(), "keystone.wasm"))
+		engine, err := NewEngine(ARCH_X86, MODE_32)
+		require.ErrorContains(t, err, "failed to read wasm module in KEYSTONE_WASM")
+		require.Nil(t, engine)
+	})
+
+	t.Run("read once", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keystone.wasm")
+		err := os.WriteFile(path, []byte("wasm"), 0600)
+		require.NoError(t, err)
+		t.Setenv(ModuleEnv, path)
+		m1, err := defaultModule()
+		require.NoError(t, err)
+		require.Equal(t, []byte("wasm"), m1.bin)
+		m2, err := defaultModule()
+		require.NoError(t, err)
+		require.Same(t, m1, m2)
+	})
+
+	t.Run("supplied", func(t *testing.T) {
+		t.Setenv(ModuleEnv, filepath.Join(t.TempDir(), "keystone.wasm"))
+		SetModule([]byte("wasm"))
+		defer SetModule(nil)
+		m, err := defaultModule()
+		require.NoError(t, err)
+		require.Equal(t, []byte("wasm"), m.bin)
+	})
+}
+
+func TestDecompressModule(t *testing.T) {
+	t.Run("invalid data", func(t *testing.T) {
+		m, err := decompressModule([]byte("not gzip"))
+		require.ErrorContains(t, err, "failed to decompress embedded wasm module")
+		require.Nil(t, m)
+	})
+
+	compress := func(bin []byte) []byte {
+		buf := bytes.Buffer{}
+		gw := gzip.NewWriter(&buf)
+		_, err := gw.Write(bin)
+		require.NoError(t, err)
+		err = gw.Close()
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		if moduleSHA256 == "" {
+			t.Skip("the hash of wasm module is not pinned")
+		}
+		m, err := decompressModule(compress([]byte("corrupted module")))
+		require.ErrorContains(t, err, "embedded wasm module is corrupted")
+		require.Nil(t, m)
+	})
+
+	t.Run("not pinned", func(t *testing.T) {
+		if moduleSHA256 != "" {
+			t.Skip("the hash of wasm module is pinned")
+		}
+		m, err := decompressModule(compress([]byte("module")))
+		require.EqualError(t, err, "the hash of embedded wasm module is not pinned in module_hash.go")
+		require.Nil(t, m)
+	})
+}
+
+func TestModuleInfo(t *testing.T) {
+	info, err := ModuleInfo()
+	require.NoError(t, err)
+	require.Len(t, info.SHA256, 64)
+	require.NotZero(t, info.Size)
+	require.NotEmpty(t, info.Version)
+	require.Contains(t, info.Exports, "ks_asm")
+	require.Contains(t, info.Exports, "malloc")
+}
+
+func TestCompiledRelease(t *testing.T) {
+	bin := fakeModule(nil)
+	key := compiledKey{hash: sha256.Sum256(bin)}
+	load := func() *compiled {
+		compiledMu.Lock()
+		defer compiledMu.Unlock()
+		return compiledM[key]
+	}
+
+	e1, err := NewEngineWithModule(bin, ARCH_X86, MODE_32)
+	require.NoError(t, err)
+	e2, err := NewEngineWithModule(bin, ARCH_X86, MODE_32)
+	require.NoError(t, err)
+	c := load()
+	require.NotNil(t, c)
+	require.Same(t, c, e2.compiled)
+	require.Equal(t, 2, c.refs)
+
+	err = e1.Close()
+	require.NoError(t, err)
+	require.Same(t, c, load())
+	require.Equal(t, 1, c.refs)
+
+	// the runtime is closed with the last engine
+	err = e2.Close()
+	require.NoError(t, err)
+	require.Nil(t, load())
+	require.Zero(t, c.refs)
+
+	// close again will not release it again
+	err = e2.Close()
+	require.NoError(t, err)
+	require.Zero(t, c.refs)
+
+	// compile again for the new engine
+	e3, err := NewEngineWithModule(bin, ARCH_X86, MODE_32)
+	require.NoError(t, err)
+	require.NotSame(t, c, e3.compiled)
+	err = e3.Close()
+	require.NoError(t, err)
+	require.Nil(t, load())
+}
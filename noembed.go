@@ -0,0 +1,8 @@
+//go:build keystone_noembed
+
+package keystone
+
+// compressedModule is not embedded with build tag keystone_noembed,
+// the module must be supplied with SetModule, SetModuleFile,
+// SetModuleFS or the environment variable KEYSTONE_WASM.
+var compressedModule []byte
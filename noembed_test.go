@@ -0,0 +1,61 @@
+//go:build keystone_noembed
+
+package keystone
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain is used to supply the wasm module for the other tests with
+// the environment variable, so it is still used after SetModule(nil).
+func TestMain(m *testing.M) {
+	if os.Getenv(ModuleEnv) == "" {
+		_ = os.Setenv(ModuleEnv, "wasm/keystone.wasm")
+	}
+	os.Exit(m.Run())
+}
+
+func TestNoEmbed(t *testing.T) {
+	m, err := embeddedModule()
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	t.Run("not supplied", func(t *testing.T) {
+		t.Setenv(ModuleEnv, "")
+
+		engine, err := NewEngine(ARCH_X86, MODE_32)
+		require.ErrorIs(t, err, ErrNoModule)
+		require.Nil(t, engine)
+	})
+
+	t.Run("supplied", func(t *testing.T) {
+		bin, err := os.ReadFile("wasm/keystone.wasm")
+		require.NoError(t, err)
+		fsys := fstest.MapFS{"keystone.wasm": {Data: bin}}
+		err = SetModuleFS(fsys, "keystone.wasm")
+		require.NoError(t, err)
+		defer SetModule(nil)
+
+		m, err := defaultModule()
+		require.NoError(t, err)
+		require.Equal(t, bin, m.bin)
+
+		err = SetModuleFS(fsys, "not_exist.wasm")
+		require.ErrorContains(t, err, "failed to read wasm module")
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Setenv(ModuleEnv, "")
+
+		err := SetModuleFile("wasm/keystone.wasm")
+		require.NoError(t, err)
+		SetModule(nil)
+
+		_, err = defaultModule()
+		require.ErrorIs(t, err, ErrNoModule)
+	})
+}
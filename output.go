@@ -0,0 +1,75 @@
+package keystone
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// the errno of WASI that returned by fd_write.
+const (
+	wasiErrnoSuccess = 0
+	wasiErrnoBadf    = 8
+	wasiErrnoFault   = 21
+)
+
+// outputKey is the context key of the buffer that
+// captures the output of stdout and stderr.
+type outputKey struct{}
+
+// withOutput is used to capture the output that LLVM writes to
+// stdout and stderr during the call with the returned context.
+func withOutput(ctx context.Context) (context.Context, *bytes.Buffer) {
+	buf := bytes.NewBuffer(nil)
+	return context.WithValue(ctx, outputKey{}, buf), buf
+}
+
+// outputLines is used to split the captured output to non-empty lines.
+func outputLines(buf *bytes.Buffer) []string {
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fdWrite is the WASI fd_write that called by the keystone module, the data
+// write to stdout and stderr is captured by the buffer in context, it is
+// discarded if the context not contains the buffer.
+func fdWrite(ctx context.Context, mod api.Module, fd, iovs, iovsLen, nwritten int32) int32 {
+	if fd != 1 && fd != 2 {
+		return wasiErrnoBadf
+	}
+	memory := mod.Memory()
+	buf, _ := ctx.Value(outputKey{}).(*bytes.Buffer)
+	var n uint32
+	for i := uint32(0); i < uint32(iovsLen); i++ {
+		iov := uint32(iovs) + i*8
+		ptr, ok := memory.ReadUint32Le(iov)
+		if !ok {
+			return wasiErrnoFault
+		}
+		size, ok := memory.ReadUint32Le(iov + 4)
+		if !ok {
+			return wasiErrnoFault
+		}
+		data, ok := memory.Read(ptr, size)
+		if !ok {
+			return wasiErrnoFault
+		}
+		if buf != nil {
+			buf.Write(data)
+		}
+		n += size
+	}
+	if !memory.WriteUint32Le(uint32(nwritten), n) {
+		return wasiErrnoFault
+	}
+	return wasiErrnoSuccess
+}
@@ -0,0 +1,110 @@
+package keystone
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+func TestFdWrite(t *testing.T) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer func() { _ = runtime.Close(ctx) }()
+
+	// (memory (export "memory") 1)
+	bin := append([]byte{}, wasm.Magic...)
+	bin = append(bin, 0x05, 0x03, 0x01, 0x00, 0x01)
+	bin = append(bin, 0x07, 0x0A, 0x01, 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00)
+	mod, err := runtime.Instantiate(ctx, bin)
+	require.NoError(t, err)
+	memory := mod.Memory()
+
+	// two iovec at 0x100 that point to the data at 0x200
+	write := func(t *testing.T, ctx context.Context, mod api.Module, fd int32) int32 {
+		require.True(t, memory.WriteString(0x200, "warning: foo\nbar\n"))
+		require.True(t, memory.WriteUint32Le(0x100, 0x200))
+		require.True(t, memory.WriteUint32Le(0x104, 13))
+		require.True(t, memory.WriteUint32Le(0x108, 0x20D))
+		require.True(t, memory.WriteUint32Le(0x10C, 4))
+		return fdWrite(ctx, mod, fd, 0x100, 2, 0x300)
+	}
+
+	t.Run("stderr", func(t *testing.T) {
+		callCtx, output := withOutput(ctx)
+		errno := write(t, callCtx, mod, 2)
+		require.Equal(t, int32(wasiErrnoSuccess), errno)
+		n, ok := memory.ReadUint32Le(0x300)
+		require.True(t, ok)
+		require.Equal(t, uint32(17), n)
+		require.Equal(t, []string{"warning: foo", "bar"}, outputLines(output))
+	})
+
+	t.Run("without buffer", func(t *testing.T) {
+		errno := write(t, ctx, mod, 1)
+		require.Equal(t, int32(wasiErrnoSuccess), errno)
+	})
+
+	t.Run("invalid fd", func(t *testing.T) {
+		errno := write(t, ctx, mod, 3)
+		require.Equal(t, int32(wasiErrnoBadf), errno)
+	})
+
+	t.Run("invalid iovec", func(t *testing.T) {
+		errno := fdWrite(ctx, mod, 2, 0x10000, 1, 0x300)
+		require.Equal(t, int32(wasiErrnoFault), errno)
+	})
+}
+
+func TestEngine_CaptureOutput(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	// the fake ks_asm writes "p oops" to stderr by fd_write
+	result, err := engine.AssembleResult("p oops\nn", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"p oops"}, result.Warnings)
+	require.Equal(t, []byte{0xC3}, result.Inst)
+
+	_, err = engine.AssembleResult("p oops\nx", 0)
+	var ke *KeystoneError
+	require.ErrorAs(t, err, &ke)
+	require.Equal(t, []string{"p oops"}, ke.Warnings)
+	require.ErrorContains(t, err, "\n    p oops")
+
+	// the output is captured for each call
+	result, err = engine.AssembleResult("n", 0)
+	require.NoError(t, err)
+	require.Empty(t, result.Warnings)
+}
+
+func TestEngine_Warnings(t *testing.T) {
+	engine, err := NewEngine(ARCH_X86, MODE_32)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+	err = engine.Option(OPT_SYNTAX, OPT_SYNTAX_ATT)
+	require.NoError(t, err)
+	const warning = "scale factor without index register is ignored"
+
+	t.Run("success", func(t *testing.T) {
+		result, err := engine.AssembleResult("movl (%ebx,4), %eax\n", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x8B, 0x03}, result.Inst)
+		require.Len(t, result.Warnings, 1)
+		require.Contains(t, result.Warnings[0], warning)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		_, err := engine.AssembleResult("movl (%ebx,4), %eax\ninvalid\n", 0)
+		var ke *KeystoneError
+		require.ErrorAs(t, err, &ke)
+		require.Len(t, ke.Warnings, 1)
+		require.Contains(t, ke.Warnings[0], warning)
+		require.ErrorContains(t, err, warning)
+	})
+}
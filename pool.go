@@ -0,0 +1,201 @@
+package keystone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrPoolClosed is returned when get engine from a closed pool.
+var ErrPoolClosed = errors.New("engine pool is closed")
+
+// Pool is a goroutine-safe pool of engines, it will create engines for
+// each target on demand and the number of engines will not exceed the max.
+type Pool struct {
+	max    int
+	config *EngineConfig
+
+	mu     sync.Mutex
+	idle   map[Target][]*Engine
+	lent   map[*Engine]Target
+	total  int
+	wait   chan struct{}
+	closed bool
+}
+
+// NewPool is used to create a pool that contains up to max engines,
+// if max is less than 1, it will be set to 1. The config is used for
+// create engines, it can be nil.
+func NewPool(max int, config *EngineConfig) *Pool {
+	if max < 1 {
+		max = 1
+	}
+	return &Pool{
+		max:    max,
+		config: config,
+		idle:   make(map[Target][]*Engine),
+		lent:   make(map[*Engine]Target),
+		wait:   make(chan struct{}),
+	}
+}
+
+// Get is used to get an engine with the target from pool, if the number of
+// engines reaches the max and all are in use, it will wait until an engine
+// is returned or the context is done. The engine must be returned with Put.
+func (p *Pool) Get(ctx context.Context, target Target) (*Engine, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		// reuse an idle engine with the same target
+		if engines := p.idle[target]; len(engines) > 0 {
+			engine := engines[len(engines)-1]
+			p.idle[target] = engines[:len(engines)-1]
+			p.lent[engine] = target
+			p.mu.Unlock()
+			return engine, nil
+		}
+		// create a new engine if not reach the max
+		if p.total < p.max {
+			p.total++
+			p.mu.Unlock()
+			return p.newEngine(ctx, target)
+		}
+		// replace an idle engine with other target
+		if old := p.evict(); old != nil {
+			p.mu.Unlock()
+			_ = old.Close()
+			return p.newEngine(ctx, target)
+		}
+		wait := p.wait
+		p.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// evict is used to remove an idle engine, the caller must hold the lock.
+func (p *Pool) evict() *Engine {
+	for target, engines := range p.idle {
+		if len(engines) == 0 {
+			continue
+		}
+		engine := engines[len(engines)-1]
+		p.idle[target] = engines[:len(engines)-1]
+		return engine
+	}
+	return nil
+}
+
+// newEngine is used to create an engine that counted in total.
+func (p *Pool) newEngine(ctx context.Context, target Target) (*Engine, error) {
+	engine, err := NewEngineWithConfig(ctx, target.Arch, target.Mode, p.config)
+	if err == nil && target.Syntax != 0 {
+		err = engine.Option(OPT_SYNTAX, target.Syntax)
+		if err != nil {
+			_ = engine.Close()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.total--
+		p.notify()
+		return nil, err
+	}
+	p.lent[engine] = target
+	return engine, nil
+}
+
+// notify is used to wake up the goroutines that wait for an
+// engine, the caller must hold the lock.
+func (p *Pool) notify() {
+	close(p.wait)
+	p.wait = make(chan struct{})
+}
+
+// Put is used to return an engine to pool. If the engine is not usable, the
+// options or the symbol resolver are changed, or the pool is closed, it will
+// be closed, so the next caller will not get the state of the last borrower.
+func (p *Pool) Put(engine *Engine) {
+	p.mu.Lock()
+	target, ok := p.lent[engine]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.lent, engine)
+	if p.closed || !engine.pristine(target) {
+		p.total--
+		p.notify()
+		p.mu.Unlock()
+		_ = engine.Close()
+		return
+	}
+	p.idle[target] = append(p.idle[target], engine)
+	p.notify()
+	p.mu.Unlock()
+}
+
+// pristine is used to check the engine is usable and its state is the same as
+// the new engine of the target, the removed symbol resolver is allowed.
+func (e *Engine) pristine(target Target) bool {
+	if e.closed || e.trap != nil || e.module.IsClosed() || e.resolver != nil {
+		return false
+	}
+	for _, opt := range e.options {
+		switch {
+		case opt.typ == OPT_SYM_RESOLVER && opt.val == 0:
+		case opt.typ == OPT_SYNTAX && opt.val == target.Syntax:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Assemble is used to assemble input source code with an engine of the target.
+func (p *Pool) Assemble(ctx context.Context, target Target, src string, addr uint64) ([]byte, error) {
+	engine, err := p.Get(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Put(engine)
+	return engine.AssembleContext(ctx, src, addr)
+}
+
+// Close is used to close all idle engines in pool, the
+// engines in use will be closed when they are returned.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	var engines []*Engine
+	for _, idle := range p.idle {
+		engines = append(engines, idle...)
+	}
+	p.idle = make(map[Target][]*Engine)
+	p.total -= len(engines)
+	p.notify()
+	p.mu.Unlock()
+	var errs []error
+	for _, engine := range engines {
+		err := engine.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to close engines: %w", errors.Join(errs...))
+	}
+	return nil
+}
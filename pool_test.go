@@ -0,0 +1,192 @@
+package keystone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	x86 := Target{Arch: ARCH_X86, Mode: MODE_32, Syntax: OPT_SYNTAX_INTEL}
+	x64 := Target{Arch: ARCH_X86, Mode: MODE_64, Syntax: OPT_SYNTAX_INTEL}
+	arm := Target{Arch: ARCH_ARM64, Mode: MODE_LITTLE_ENDIAN}
+
+	t.Run("common", func(t *testing.T) {
+		pool := NewPool(2, nil)
+
+		inst, err := pool.Assemble(context.Background(), x86, "xor eax, eax\nret\n", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x31, 0xC0, 0xC3}, inst)
+
+		inst, err = pool.Assemble(context.Background(), x64, "xor rax, rax\nret\n", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x48, 0x31, 0xC0, 0xC3}, inst)
+
+		err = pool.Close()
+		require.NoError(t, err)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		pool := NewPool(4, nil)
+
+		tests := []struct {
+			target   Target
+			src      string
+			expected []byte
+		}{
+			{x86, "xor eax, eax\nret\n", []byte{0x31, 0xC0, 0xC3}},
+			{x64, "xor rax, rax\nret\n", []byte{0x48, 0x31, 0xC0, 0xC3}},
+			{arm, "ret\n", []byte{0xC0, 0x03, 0x5F, 0xD6}},
+		}
+		wg := sync.WaitGroup{}
+		errCh := make(chan error, 64)
+		for i := 0; i < 64; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					test := tests[(i+j)%len(tests)]
+					inst, err := pool.Assemble(context.Background(), test.target, test.src, 0)
+					if err != nil {
+						errCh <- err
+						return
+					}
+					if !bytes.Equal(test.expected, inst) {
+						errCh <- fmt.Errorf("unexpected output: %X", inst)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errCh)
+		for err := range errCh {
+			require.NoError(t, err)
+		}
+		require.LessOrEqual(t, pool.total, 4)
+
+		err := pool.Close()
+		require.NoError(t, err)
+		require.Zero(t, pool.total)
+	})
+
+	t.Run("wait for engine", func(t *testing.T) {
+		pool := NewPool(1, nil)
+
+		engine, err := pool.Get(context.Background(), x86)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err = pool.Get(ctx, x64)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			pool.Put(engine)
+		}()
+		engine, err = pool.Get(context.Background(), x64)
+		require.NoError(t, err)
+		inst, err := engine.Assemble("xor rax, rax\n", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x48, 0x31, 0xC0}, inst)
+		pool.Put(engine)
+
+		err = pool.Close()
+		require.NoError(t, err)
+	})
+
+	t.Run("state is reset", func(t *testing.T) {
+		pool := NewPool(1, nil)
+
+		engine, err := pool.Get(context.Background(), x86)
+		require.NoError(t, err)
+		err = engine.SetSymbolResolver(func(string) (uint64, bool) {
+			return 0x1000, true
+		})
+		require.NoError(t, err)
+		pool.Put(engine)
+		require.True(t, engine.closed)
+
+		// the symbol resolver of the last borrower is not used
+		_, err = pool.Assemble(context.Background(), x86, "jmp missing\n", 0)
+		require.ErrorIs(t, err, ErrSymbolMissing)
+
+		err = pool.Close()
+		require.NoError(t, err)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		pool := NewPool(1, nil)
+		err := pool.Close()
+		require.NoError(t, err)
+
+		inst, err := pool.Assemble(context.Background(), x86, "nop\n", 0)
+		require.ErrorIs(t, err, ErrPoolClosed)
+		require.Nil(t, inst)
+	})
+}
+
+func TestPool_Put(t *testing.T) {
+	target := Target{Arch: ARCH_X86, Mode: MODE_32, Syntax: OPT_SYNTAX_INTEL}
+	lend := func(t *testing.T, pool *Pool) *Engine {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		err = engine.Option(OPT_SYNTAX, target.Syntax)
+		require.NoError(t, err)
+		pool.lent[engine] = target
+		pool.total++
+		return engine
+	}
+	for _, item := range []struct {
+		name   string
+		change func(engine *Engine) error
+		reused bool
+	}{
+		{"unchanged", func(*Engine) error { return nil }, true},
+		{"resolver removed", func(engine *Engine) error {
+			return engine.SetSymbolResolver(nil)
+		}, true},
+		{"syntax", func(engine *Engine) error {
+			return engine.Option(OPT_SYNTAX, OPT_SYNTAX_ATT)
+		}, false},
+		{"resolver", func(engine *Engine) error {
+			return engine.SetSymbolResolver(func(string) (uint64, bool) {
+				return 0, false
+			})
+		}, false},
+		{"trapped", func(engine *Engine) error {
+			_, err := engine.Assemble("ud2", 0)
+			if err == nil {
+				return errors.New("not trapped")
+			}
+			return nil
+		}, false},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			pool := NewPool(1, nil)
+			engine := lend(t, pool)
+			err := item.change(engine)
+			require.NoError(t, err)
+
+			pool.Put(engine)
+			require.Equal(t, !item.reused, engine.closed)
+			if item.reused {
+				require.Equal(t, []*Engine{engine}, pool.idle[target])
+				require.Equal(t, 1, pool.total)
+			} else {
+				require.Empty(t, pool.idle[target])
+				require.Zero(t, pool.total)
+			}
+
+			err = pool.Close()
+			require.NoError(t, err)
+		})
+	}
+}
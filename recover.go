@@ -0,0 +1,94 @@
+package keystone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// option is an option that set by Engine.Option.
+type option struct {
+	typ OptionType
+	val OptionValue
+}
+
+// saveOption is used to record the option for apply it when recover, the
+// symbol resolver is the index in function table of the module instance,
+// so it is installed again with SetSymbolResolver instead of record.
+func (e *Engine) saveOption(typ OptionType, val OptionValue) {
+	if typ == OPT_SYM_RESOLVER {
+		return
+	}
+	for i := range e.options {
+		if e.options[i].typ == typ {
+			e.options[i].val = val
+			return
+		}
+	}
+	e.options = append(e.options, option{typ: typ, val: val})
+}
+
+// trapped is used to mark the engine as unhealthy when the wasm call traps,
+// it returns the error for the caller, the abort error of keystone is
+// returned as is, so that errors.Is(err, ErrEngineAborted) can be used.
+func (e *Engine) trapped(name string, err error) error {
+	e.trap = err
+	var ae *abortError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	return fmt.Errorf("failed to call %s: %s", name, err)
+}
+
+// checkHealth is used to recover the engine if the last wasm call trapped or
+// the module is closed, the engine that closed by Close will not be recovered.
+func (e *Engine) checkHealth(ctx context.Context) error {
+	if e.closed {
+		return ErrEngineClosed
+	}
+	if e.trap == nil && !e.module.IsClosed() {
+		return nil
+	}
+	err := e.restore(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to recover engine: %w", err)
+	}
+	return nil
+}
+
+// restore is used to instantiate the wasm module again, open keystone with
+// the same arch and mode, then apply the options and the symbol resolver.
+// The memory of the module instance may be corrupted after a trap, so the
+// module instance and the symbol resolver modules are closed directly.
+func (e *Engine) restore(ctx context.Context) error {
+	for _, mod := range e.resolverMods {
+		_ = mod.Close(ctx)
+	}
+	e.resolverMods = nil
+	e.resolverFn = 0
+	_ = e.module.Close(ctx)
+	// clear the trap before initialize, otherwise free will be skipped
+	trap := e.trap
+	e.trap = nil
+	err := e.instantiate(ctx)
+	if err != nil {
+		e.trap = trap
+		return err
+	}
+	// if failed to apply options, recover again at the next call
+	for _, opt := range e.options {
+		err = e.option(opt.typ, opt.val)
+		if err != nil {
+			e.trap = err
+			return err
+		}
+	}
+	if e.resolver != nil {
+		err = e.SetSymbolResolver(e.resolver)
+		if err != nil {
+			e.trap = err
+			return err
+		}
+	}
+	return nil
+}
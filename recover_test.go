@@ -0,0 +1,135 @@
+package keystone
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Recover(t *testing.T) {
+	t.Run("trap", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		err = engine.Option(OPT_SYNTAX, OPT_SYNTAX_ATT)
+		require.NoError(t, err)
+		err = engine.SetSymbolResolver(func(string) (uint64, bool) {
+			return 0, false
+		})
+		require.NoError(t, err)
+		module := engine.module
+
+		// inject a trap with "unreachable"
+		inst, err := engine.Assemble("ud2", 0)
+		require.ErrorContains(t, err, "failed to call ks_asm: wasm error: unreachable")
+		require.NotErrorIs(t, err, ErrEngineAborted)
+		require.Nil(t, inst)
+		require.Error(t, engine.trap)
+
+		inst, err = engine.Assemble("nop", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xC3}, inst)
+		require.NoError(t, engine.trap)
+
+		// the module is instantiated again
+		require.True(t, module.IsClosed())
+		require.NotSame(t, module, engine.module)
+
+		// the options are applied again
+		val, ok := engine.memory.ReadUint32Le(48)
+		require.True(t, ok)
+		require.Equal(t, uint32(engine.resolverFn), val)
+		require.NotZero(t, engine.resolverFn)
+		require.Equal(t, []option{{typ: OPT_SYNTAX, val: OPT_SYNTAX_ATT}}, engine.options)
+	})
+
+	t.Run("option", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		err = engine.Option(OPT_SYNTAX, OPT_SYNTAX_ATT)
+		require.NoError(t, err)
+		err = engine.Option(OPT_SYNTAX, OPT_SYNTAX_NASM)
+		require.NoError(t, err)
+
+		_, err = engine.Assemble("throw", 0)
+		require.ErrorIs(t, err, ErrEngineAborted)
+
+		// recovered by Option
+		err = engine.Option(OPT_SYM_RESOLVER, 0)
+		require.NoError(t, err)
+		val, ok := engine.memory.ReadUint32Le(48)
+		require.True(t, ok)
+		require.Zero(t, val)
+		require.Equal(t, []option{{typ: OPT_SYNTAX, val: OPT_SYNTAX_NASM}}, engine.options)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+		module := engine.module
+
+		// the fake ks_asm waits forever with "w"
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		inst, err := engine.AssembleContext(ctx, "wait", 0)
+		var ce *CanceledError
+		require.True(t, errors.As(err, &ce))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Nil(t, inst)
+		require.True(t, module.IsClosed())
+
+		// the next call recovers the engine
+		inst, err = engine.Assemble("nop", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xC3}, inst)
+		require.NotSame(t, module, engine.module)
+		require.Equal(t, 2, engine.Stats().LiveAllocs)
+	})
+
+	t.Run("module closed", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		err = engine.module.Close(context.Background())
+		require.NoError(t, err)
+
+		inst, err := engine.Assemble("nop", 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xC3}, inst)
+	})
+
+	t.Run("failed to recover", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		_, err = engine.Assemble("throw", 0)
+		require.Error(t, err)
+		// the unknown option will fail after instantiate again
+		engine.options = append(engine.options, option{typ: 3})
+
+		result, err := engine.AssembleResult("nop", 0)
+		require.ErrorContains(t, err, "failed to recover engine")
+		require.ErrorIs(t, err, ErrOptInvalid)
+		require.Nil(t, result)
+	})
+
+	t.Run("close after trap", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+
+		_, err = engine.Assemble("abort", 0)
+		require.ErrorIs(t, err, ErrEngineAborted)
+
+		err = engine.Close()
+		require.NoError(t, err)
+	})
+}
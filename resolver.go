@@ -0,0 +1,163 @@
+package keystone
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/moloch--/go-keystone/internal/wasm"
+)
+
+// the name prefix of the module instances, the resolver module need
+// import the function table from the keystone module.
+const (
+	keystoneModule = "keystone"
+	resolverModule = "keystone_resolver"
+)
+
+// SymbolResolver is used to resolve the symbol that not defined in
+// source code, return false if the symbol is unknown.
+type SymbolResolver func(symbol string) (uint64, bool)
+
+// SetSymbolResolver is used to set the resolver for undefined symbols,
+// set nil for remove the current resolver.
+func (e *Engine) SetSymbolResolver(resolver SymbolResolver) error {
+	err := e.checkHealth(e.context)
+	if err != nil {
+		return err
+	}
+	if resolver == nil {
+		err = e.Option(OPT_SYM_RESOLVER, 0)
+		if err != nil {
+			return err
+		}
+		e.resolver = nil
+		return nil
+	}
+	if e.resolverFn == 0 {
+		fn, err := e.installResolver()
+		if err != nil {
+			return fmt.Errorf("failed to install symbol resolver: %s", err)
+		}
+		e.resolverFn = fn
+	}
+	err = e.Option(OPT_SYM_RESOLVER, OptionValue(e.resolverFn))
+	if err != nil {
+		return err
+	}
+	e.resolver = resolver
+	return nil
+}
+
+// installResolver is used to append a function to the function table of
+// keystone module, it will call the host function resolveSymbol, and the
+// index in the table can be used as the function pointer for ks_option.
+func (e *Engine) installResolver() (uint32, error) {
+	table := e.compiled.table
+	if table == "" {
+		return 0, errors.New("function table is not exported by keystone module")
+	}
+	host := fmt.Sprintf("%s_%d", resolverModule, e.id)
+	builder := e.compiled.runtime.NewHostModuleBuilder(host)
+	builder.NewFunctionBuilder().WithFunc(e.resolveSymbol).Export("resolve")
+	hostMod, err := builder.Instantiate(e.context)
+	if err != nil {
+		return 0, fmt.Errorf("failed to instantiate host module: %s", err)
+	}
+	e.resolverMods = append(e.resolverMods, hostMod)
+	thunk := resolverThunk(e.module.Name(), host, table)
+	thunkMod, err := e.compiled.runtime.Instantiate(e.context, thunk)
+	if err != nil {
+		return 0, fmt.Errorf("failed to instantiate thunk module: %s", err)
+	}
+	e.resolverMods = append(e.resolverMods, thunkMod)
+	rets, err := thunkMod.ExportedFunction("install").Call(e.context)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call install: %s", err)
+	}
+	idx := int32(rets[0])
+	if idx <= 0 {
+		return 0, errors.New("function table of keystone module is not growable")
+	}
+	return uint32(idx), nil
+}
+
+// resolveSymbol is the host function that called by keystone with
+// bool (*ks_sym_resolver)(const char *symbol, uint64_t *value).
+func (e *Engine) resolveSymbol(symbol, value uint32) uint32 {
+	if e.resolver == nil {
+		return 0
+	}
+	val, ok := e.resolver(e.readString(symbol))
+	if !ok {
+		return 0
+	}
+	if !e.memory.WriteUint64Le(value, val) {
+		return 0
+	}
+	return 1
+}
+
+// resolverThunk is used to build a wasm module that import the function
+// table from keystone module, the exported function "install" will append
+// the function "thunk" to the table and return the index of it.
+func resolverThunk(keystone, host, table string) []byte {
+	section := func(bin []byte, id byte, data []byte) []byte {
+		bin = append(bin, id)
+		bin = appendU32(bin, uint32(len(data)))
+		return append(bin, data...)
+	}
+	bin := append([]byte{}, wasm.Magic...)
+	// type section
+	// 0: (i32, i32) -> i32
+	// 1: () -> i32
+	bin = section(bin, 1, []byte{
+		0x02,
+		0x60, 0x02, 0x7F, 0x7F, 0x01, 0x7F,
+		0x60, 0x00, 0x01, 0x7F,
+	})
+	// import section
+	imports := []byte{0x02}
+	imports = appendName(imports, host)
+	imports = appendName(imports, "resolve")
+	imports = append(imports, wasm.ExternFunc, 0x00)
+	imports = appendName(imports, keystone)
+	imports = appendName(imports, table)
+	imports = append(imports, wasm.ExternTable, 0x70, 0x00, 0x00)
+	bin = section(bin, 2, imports)
+	// function section
+	bin = section(bin, 3, []byte{0x02, 0x00, 0x01})
+	// export section, export "thunk" for declare it can be used by ref.func
+	exports := []byte{0x02}
+	exports = appendName(exports, "thunk")
+	exports = append(exports, wasm.ExternFunc, 0x01)
+	exports = appendName(exports, "install")
+	exports = append(exports, wasm.ExternFunc, 0x02)
+	bin = section(bin, 7, exports)
+	// code section
+	code := []byte{0x02}
+	// thunk: local.get 0, local.get 1, call 0
+	thunk := []byte{0x00, 0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x0B}
+	code = appendU32(code, uint32(len(thunk)))
+	code = append(code, thunk...)
+	// install: ref.func 1, i32.const 1, table.grow 0
+	install := []byte{0x00, 0xD2, 0x01, 0x41, 0x01, 0xFC, 0x0F, 0x00, 0x0B}
+	code = appendU32(code, uint32(len(install)))
+	code = append(code, install...)
+	return section(bin, 10, code)
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	b = appendU32(b, uint32(len(name)))
+	return append(b, name...)
+}
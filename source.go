@@ -0,0 +1,140 @@
+package keystone
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelRe is used to match the label definition at the start of line.
+var labelRe = regexp.MustCompile(`^\s*([A-Za-z_.$][A-Za-z0-9_.$@]*)\s*:`)
+
+// splitLines is used to split source code to lines.
+func splitLines(src string) []string {
+	return strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+}
+
+// findLabels is used to find the labels that defined in source code,
+// the key is the index of line and the value is the label name.
+func findLabels(lines []string) map[int]string {
+	labels := make(map[int]string)
+	for i, line := range lines {
+		m := labelRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		labels[i] = m[1]
+	}
+	return labels
+}
+
+// withLabels is used to call fn with a symbol resolver that resolve the
+// labels first, then the current resolver. The current resolver is
+// restored after fn returns.
+func (e *Engine) withLabels(labels map[string]uint64, fn func() error) error {
+	prev := e.resolver
+	err := e.SetSymbolResolver(func(symbol string) (uint64, bool) {
+		if val, ok := labels[symbol]; ok {
+			return val, true
+		}
+		if prev != nil {
+			return prev(symbol)
+		}
+		return 0, false
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = e.SetSymbolResolver(prev) }()
+	return fn()
+}
+
+// statementLine is used to find the line of the n-th statement that LLVM
+// parsed, start from 0. Each line is a statement even if it is empty, and
+// the label with an instruction after it is counted as another statement.
+func statementLine(lines []string, n uint32) int {
+	for i, line := range lines {
+		count := uint32(1)
+		m := labelRe.FindStringIndex(line)
+		if m != nil && strings.TrimSpace(line[m[1]:]) != "" {
+			count++
+		}
+		if n < count {
+			return i
+		}
+		n -= count
+	}
+	return len(lines)
+}
+
+// onlyLine returns the index of the only non-empty line, or -1.
+func onlyLine(lines []string) int {
+	idx := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if idx != -1 {
+			return -1
+		}
+		idx = i
+	}
+	return idx
+}
+
+// locate is used to find the line that caused the error, it is skipped if
+// the source code only has one statement. Otherwise, the line of statement
+// count that keystone reported is checked first, if it is not the first line
+// that fails, the line is found by bisect the source code.
+func (e *Engine) locate(ctx context.Context, src string, addr uint64, count uint32, ke *KeystoneError) {
+	lines := splitLines(src)
+	n := onlyLine(lines)
+	if n == -1 {
+		n = e.search(ctx, lines, addr, count, ke.Code)
+	}
+	if n < 0 || n >= len(lines) {
+		return
+	}
+	line := lines[n]
+	text := strings.TrimLeft(line, " \t")
+	ke.Line = n + 1
+	ke.Column = len(line) - len(text) + 1
+	ke.Text = strings.TrimSpace(text)
+}
+
+// search is used to find the first line that the prefix of source code fails
+// with the error code. The labels that defined in source code are resolved,
+// so the forward references in the prefix of source code will not cause error.
+func (e *Engine) search(ctx context.Context, lines []string, addr uint64, count uint32, code Error) int {
+	fails := func(i int) bool {
+		prefix := strings.Join(lines[:i+1], "\n")
+		_, err := e.assemble(ctx, prefix, addr)
+		var ke *KeystoneError
+		return errors.As(err, &ke) && ke.Code == code
+	}
+	var n int
+	search := func() error {
+		n = statementLine(lines, count)
+		if n < len(lines) && fails(n) && (n == 0 || !fails(n-1)) {
+			return nil
+		}
+		n = sort.Search(len(lines), fails)
+		return nil
+	}
+	labelLine := findLabels(lines)
+	if len(labelLine) == 0 {
+		_ = search()
+		return n
+	}
+	labels := make(map[string]uint64, len(labelLine))
+	for _, label := range labelLine {
+		labels[label] = addr
+	}
+	// if failed to set resolver, bisect without it
+	if e.withLabels(labels, search) != nil {
+		_ = search()
+	}
+	return n
+}
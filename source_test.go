@@ -0,0 +1,83 @@
+package keystone
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementLine(t *testing.T) {
+	lines := splitLines("nop\n\nstart: nop\nend:\nnop")
+	for _, item := range []struct {
+		n    uint32
+		line int
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 2},
+		{3, 2},
+		{4, 3},
+		{5, 4},
+		{6, 5},
+	} {
+		require.Equal(t, item.line, statementLine(lines, item.n), item.n)
+	}
+}
+
+func TestEngine_Locate(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	calls := func() uint64 {
+		return engine.Stats().Calls["ks_asm"]
+	}
+	locate := func(src string) (*KeystoneError, uint64) {
+		before := calls()
+		_, err := engine.AssembleResult(src, 0)
+		var ke *KeystoneError
+		require.True(t, errors.As(err, &ke))
+		require.ErrorIs(t, err, ErrMnemonicFail)
+		return ke, calls() - before - 1
+	}
+
+	t.Run("not located", func(t *testing.T) {
+		before := calls()
+		_, err := engine.Assemble("n\nx\nn", 0)
+		var ke *KeystoneError
+		require.True(t, errors.As(err, &ke))
+		require.Zero(t, ke.Line)
+		require.Equal(t, uint64(1), calls()-before)
+	})
+
+	t.Run("single statement", func(t *testing.T) {
+		ke, n := locate("\n  x\n")
+		require.Equal(t, 2, ke.Line)
+		require.Equal(t, 3, ke.Column)
+		require.Equal(t, "x", ke.Text)
+		require.Zero(t, n)
+	})
+
+	t.Run("statement count", func(t *testing.T) {
+		ke, n := locate("n\nnn\n\n\tx n\nn\nx")
+		require.Equal(t, 4, ke.Line)
+		require.Equal(t, 2, ke.Column)
+		require.Equal(t, "x n", ke.Text)
+		require.Equal(t, uint64(2), n)
+	})
+
+	t.Run("first line", func(t *testing.T) {
+		ke, n := locate("x\nn\nn")
+		require.Equal(t, 1, ke.Line)
+		require.Equal(t, uint64(1), n)
+	})
+
+	t.Run("bisect", func(t *testing.T) {
+		// the label with instruction is counted as two statements
+		// by statementLine, but the fake module counts one
+		ke, n := locate("l: n\nn\nn\nx\nn")
+		require.Equal(t, 4, ke.Line)
+		require.Greater(t, n, uint64(2))
+	})
+}
@@ -0,0 +1,41 @@
+package keystone
+
+import (
+	"maps"
+	"time"
+)
+
+// wasmPageSize is the size of a wasm memory page.
+const wasmPageSize = 64 * 1024
+
+// EngineStats contains the statistics of engine.
+type EngineStats struct {
+	// MemoryPages is the number of pages of the wasm memory, a page is 64 KiB.
+	MemoryPages uint32
+
+	// LiveAllocs is the number of the memory that allocated by engine but
+	// not freed, it contains the scratch arena that reused by each assembly,
+	// but not contains the allocations inside keystone.
+	LiveAllocs int
+
+	// Calls is the number of calls of each exported function,
+	// the key is the C name of function, like "ks_asm".
+	Calls map[string]uint64
+
+	// AssembleTime is the cumulative time spent in assembly.
+	AssembleTime time.Duration
+}
+
+// Stats is used to get the statistics of engine, the call counts and
+// assembly time are kept after the engine is recovered from a trap.
+func (e *Engine) Stats() *EngineStats {
+	stats := EngineStats{
+		LiveAllocs:   e.liveAllocs,
+		Calls:        maps.Clone(e.calls),
+		AssembleTime: e.assembleTime,
+	}
+	if !e.module.IsClosed() {
+		stats.MemoryPages = e.memory.Size() / wasmPageSize
+	}
+	return &stats
+}
@@ -0,0 +1,85 @@
+package keystone
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Stats(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	for i := 0; i < 3; i++ {
+		_, err = engine.Assemble("nop", 0)
+		require.NoError(t, err)
+	}
+	stats := engine.Stats()
+	require.Equal(t, uint32(1), stats.MemoryPages)
+	require.Equal(t, uint64(3), stats.Calls["ks_asm"])
+	require.Equal(t, uint64(3), stats.Calls["ks_free"])
+	require.Equal(t, uint64(1), stats.Calls["ks_open"])
+	// the output cells and the source buffer in arena
+	require.Equal(t, 2, stats.LiveAllocs)
+	require.Equal(t, uint64(2), stats.Calls["malloc"])
+	require.Zero(t, stats.Calls["free"])
+	require.NotZero(t, stats.AssembleTime)
+
+	t.Run("free failed", func(t *testing.T) {
+		engine, err := newFakeEngine(map[string][]byte{"free": unreachable})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		_, err = engine.Assemble("nop", 0)
+		require.NoError(t, err)
+		// grow the source buffer
+		_, err = engine.Assemble("n"+strings.Repeat(" ", minSourceSize), 0)
+		require.ErrorContains(t, err, "failed to call free: wasm error: unreachable")
+	})
+
+	t.Run("after recover", func(t *testing.T) {
+		engine, err := newFakeEngine(nil)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, engine.Close()) }()
+
+		_, err = engine.Assemble("throw", 0)
+		require.ErrorIs(t, err, ErrEngineAborted)
+		_, err = engine.Assemble("nop", 0)
+		require.NoError(t, err)
+
+		stats := engine.Stats()
+		require.Equal(t, 2, stats.LiveAllocs)
+		require.Equal(t, uint64(2), stats.Calls["ks_asm"])
+		require.Equal(t, uint64(2), stats.Calls["ks_open"])
+	})
+}
+
+func TestEngine_Soak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip soak test in short mode")
+	}
+	engine, err := NewEngine(ARCH_X86, MODE_64)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	snippet := func(i int) string {
+		return fmt.Sprintf("mov rax, %d\nlea rbx, [rax+%d]\nxor ecx, ecx", i, i%4096)
+	}
+	// warm up the allocator of keystone
+	for i := 0; i < 1000; i++ {
+		_, err = engine.Assemble(snippet(i), 0)
+		require.NoError(t, err)
+	}
+	warm := engine.Stats()
+	for i := 0; i < 100000; i++ {
+		_, err = engine.Assemble(snippet(i), uint64(i))
+		require.NoError(t, err)
+	}
+	stats := engine.Stats()
+	require.Equal(t, warm.MemoryPages, stats.MemoryPages)
+	require.Equal(t, warm.LiveAllocs, stats.LiveAllocs)
+	require.Equal(t, uint64(101000), stats.Calls["ks_asm"])
+}
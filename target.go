@@ -0,0 +1,310 @@
+package keystone
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Target is the architecture, mode and syntax of engine,
+// if Syntax is zero, the default syntax of keystone is used.
+type Target struct {
+	Arch   Arch
+	Mode   Mode
+	Syntax OptionValue
+}
+
+// supportedTargets contains the valid arch and mode combinations,
+// it is the same as the list in kstool of keystone.
+var supportedTargets = []Target{
+	{Arch: ARCH_ARM, Mode: MODE_ARM},
+	{Arch: ARCH_ARM, Mode: MODE_ARM | MODE_BIG_ENDIAN},
+	{Arch: ARCH_ARM, Mode: MODE_THUMB},
+	{Arch: ARCH_ARM, Mode: MODE_THUMB | MODE_BIG_ENDIAN},
+	{Arch: ARCH_ARM, Mode: MODE_ARM | MODE_V8},
+	{Arch: ARCH_ARM, Mode: MODE_ARM | MODE_V8 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_ARM, Mode: MODE_THUMB | MODE_V8},
+	{Arch: ARCH_ARM, Mode: MODE_THUMB | MODE_V8 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_ARM64, Mode: MODE_LITTLE_ENDIAN},
+	{Arch: ARCH_MIPS, Mode: MODE_MIPS32},
+	{Arch: ARCH_MIPS, Mode: MODE_MIPS32 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_MIPS, Mode: MODE_MIPS64},
+	{Arch: ARCH_MIPS, Mode: MODE_MIPS64 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_X86, Mode: MODE_16},
+	{Arch: ARCH_X86, Mode: MODE_32},
+	{Arch: ARCH_X86, Mode: MODE_64},
+	{Arch: ARCH_PPC, Mode: MODE_PPC32 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_PPC, Mode: MODE_PPC64},
+	{Arch: ARCH_PPC, Mode: MODE_PPC64 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_SPARC, Mode: MODE_SPARC32},
+	{Arch: ARCH_SPARC, Mode: MODE_SPARC32 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_SPARC, Mode: MODE_SPARC64 | MODE_BIG_ENDIAN},
+	{Arch: ARCH_SYSTEMZ, Mode: MODE_BIG_ENDIAN},
+	{Arch: ARCH_HEXAGON, Mode: MODE_BIG_ENDIAN},
+	{Arch: ARCH_EVM, Mode: MODE_LITTLE_ENDIAN},
+	{Arch: ARCH_RISCV, Mode: MODE_RISCV32},
+	{Arch: ARCH_RISCV, Mode: MODE_RISCV64},
+}
+
+// SupportedTargets returns the valid arch and mode combinations.
+func SupportedTargets() []Target {
+	targets := make([]Target, len(supportedTargets))
+	copy(targets, supportedTargets)
+	return targets
+}
+
+// checkTarget is used to check the arch and mode combination is valid.
+func checkTarget(arch Arch, mode Mode) error {
+	if !arch.valid() {
+		return &KeystoneError{
+			Op:      "ks_open",
+			Code:    ERR_ARCH,
+			Message: fmt.Sprintf("invalid arch %s (KS_ERR_ARCH)", arch),
+		}
+	}
+	for _, target := range supportedTargets {
+		if target.Arch == arch && target.Mode == mode {
+			return nil
+		}
+	}
+	return &KeystoneError{
+		Op:      "ks_open",
+		Code:    ERR_MODE,
+		Message: fmt.Sprintf("invalid mode %s for arch %s (KS_ERR_MODE)", mode, arch),
+	}
+}
+
+// ArchSupported is used to check the arch is supported by the keystone wasm
+// module. If ks_arch_supported is not exported, it opens a temporary keystone
+// engine with each valid mode of the arch to check it.
+func (e *Engine) ArchSupported(arch Arch) (bool, error) {
+	err := e.checkHealth(e.context)
+	if err != nil {
+		return false, err
+	}
+	if e._ksArchSupported != nil {
+		rets, err := e.call(e.context, "ks_arch_supported", e._ksArchSupported, uint64(arch))
+		if err != nil {
+			return false, e.trapped("ks_arch_supported", err)
+		}
+		return uint32(rets[0]) != 0, nil
+	}
+	for _, target := range supportedTargets {
+		if target.Arch != arch {
+			continue
+		}
+		ok, err := e.probe(target.Arch, target.Mode)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// probe is used to open and close a temporary keystone engine
+// with the arch and mode, it returns false if the arch is invalid.
+func (e *Engine) probe(arch Arch, mode Mode) (bool, error) {
+	ptr := e.arena.cell(1)
+	rets, err := e.call(e.context, "ks_open", e._ksOpen,
+		uint64(arch), uint64(mode), uint64(ptr),
+	)
+	if err != nil {
+		return false, e.trapped("ks_open", err)
+	}
+	switch errno := Error(rets[0]); errno {
+	case ERR_OK:
+	case ERR_ARCH, ERR_MODE:
+		return false, nil
+	default:
+		return false, e.newError("ks_open", errno)
+	}
+	engine, err := e.readUint32(ptr)
+	if err != nil {
+		return false, err
+	}
+	rets, err = e.call(e.context, "ks_close", e._ksClose, uint64(engine))
+	if err != nil {
+		return false, e.trapped("ks_close", err)
+	}
+	if errno := Error(rets[0]); errno != ERR_OK {
+		return false, e.newError("ks_close", errno)
+	}
+	return true, nil
+}
+
+// tripleM maps the LLVM or Go style arch name to the target,
+// the ARM variants like "armv7" and "thumbv8eb" are parsed by parseARM.
+var tripleM = map[string]Target{
+	"i8086":       {Arch: ARCH_X86, Mode: MODE_16},
+	"i386":        {Arch: ARCH_X86, Mode: MODE_32},
+	"i486":        {Arch: ARCH_X86, Mode: MODE_32},
+	"i586":        {Arch: ARCH_X86, Mode: MODE_32},
+	"i686":        {Arch: ARCH_X86, Mode: MODE_32},
+	"386":         {Arch: ARCH_X86, Mode: MODE_32},
+	"x86":         {Arch: ARCH_X86, Mode: MODE_32},
+	"x86_64":      {Arch: ARCH_X86, Mode: MODE_64},
+	"amd64":       {Arch: ARCH_X86, Mode: MODE_64},
+	"x64":         {Arch: ARCH_X86, Mode: MODE_64},
+	"aarch64":     {Arch: ARCH_ARM64, Mode: MODE_LITTLE_ENDIAN},
+	"arm64":       {Arch: ARCH_ARM64, Mode: MODE_LITTLE_ENDIAN},
+	"aarch64_be":  {Arch: ARCH_ARM64, Mode: MODE_BIG_ENDIAN},
+	"mips":        {Arch: ARCH_MIPS, Mode: MODE_MIPS32 | MODE_BIG_ENDIAN},
+	"mipseb":      {Arch: ARCH_MIPS, Mode: MODE_MIPS32 | MODE_BIG_ENDIAN},
+	"mipsel":      {Arch: ARCH_MIPS, Mode: MODE_MIPS32},
+	"mipsle":      {Arch: ARCH_MIPS, Mode: MODE_MIPS32},
+	"mips64":      {Arch: ARCH_MIPS, Mode: MODE_MIPS64 | MODE_BIG_ENDIAN},
+	"mips64eb":    {Arch: ARCH_MIPS, Mode: MODE_MIPS64 | MODE_BIG_ENDIAN},
+	"mips64el":    {Arch: ARCH_MIPS, Mode: MODE_MIPS64},
+	"mips64le":    {Arch: ARCH_MIPS, Mode: MODE_MIPS64},
+	"ppc":         {Arch: ARCH_PPC, Mode: MODE_PPC32 | MODE_BIG_ENDIAN},
+	"powerpc":     {Arch: ARCH_PPC, Mode: MODE_PPC32 | MODE_BIG_ENDIAN},
+	"ppc64":       {Arch: ARCH_PPC, Mode: MODE_PPC64 | MODE_BIG_ENDIAN},
+	"powerpc64":   {Arch: ARCH_PPC, Mode: MODE_PPC64 | MODE_BIG_ENDIAN},
+	"ppc64le":     {Arch: ARCH_PPC, Mode: MODE_PPC64},
+	"ppc64el":     {Arch: ARCH_PPC, Mode: MODE_PPC64},
+	"powerpc64le": {Arch: ARCH_PPC, Mode: MODE_PPC64},
+	"sparc":       {Arch: ARCH_SPARC, Mode: MODE_SPARC32 | MODE_BIG_ENDIAN},
+	"sparcel":     {Arch: ARCH_SPARC, Mode: MODE_SPARC32},
+	"sparc64":     {Arch: ARCH_SPARC, Mode: MODE_SPARC64 | MODE_BIG_ENDIAN},
+	"sparcv9":     {Arch: ARCH_SPARC, Mode: MODE_SPARC64 | MODE_BIG_ENDIAN},
+	"s390x":       {Arch: ARCH_SYSTEMZ, Mode: MODE_BIG_ENDIAN},
+	"systemz":     {Arch: ARCH_SYSTEMZ, Mode: MODE_BIG_ENDIAN},
+	"hexagon":     {Arch: ARCH_HEXAGON, Mode: MODE_BIG_ENDIAN},
+	"evm":         {Arch: ARCH_EVM, Mode: MODE_LITTLE_ENDIAN},
+	"riscv32":     {Arch: ARCH_RISCV, Mode: MODE_RISCV32},
+	"riscv64":     {Arch: ARCH_RISCV, Mode: MODE_RISCV64},
+}
+
+// canonicalTriples contains the arch names that used by Target.String.
+var canonicalTriples = []string{
+	"i8086", "i386", "x86_64", "aarch64", "aarch64_be",
+	"mips", "mipsel", "mips64", "mips64el", "ppc", "ppc64", "ppc64le",
+	"sparc", "sparcel", "sparc64", "s390x", "hexagon", "evm", "riscv32", "riscv64",
+}
+
+// armRe is used to match the ARM arch name after remove the endian suffix.
+var armRe = regexp.MustCompile(`^(arm|thumb)(?:v(\d+)[a-z]*)?$`)
+
+// ParseTarget is used to parse the LLVM or Go style target triple like
+// "x86_64", "aarch64-linux-gnu", "armv7-thumb" and "mips64el". The first
+// component is the arch name, the component "thumb" selects the Thumb mode
+// of ARM, and the component like "att" selects the syntax, other components
+// like vendor and os are ignored. The big endian ARM64 like "aarch64_be" is
+// recognized but returns an error, because it is not supported by keystone.
+func ParseTarget(triple string) (Target, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(triple)), "-")
+	target, ok := tripleM[parts[0]]
+	if !ok {
+		target, ok = parseARM(parts[0])
+	}
+	if !ok {
+		return Target{}, fmt.Errorf("unknown target %q", triple)
+	}
+	for _, part := range parts[1:] {
+		if part == "thumb" && target.Arch == ARCH_ARM {
+			target.Mode = target.Mode&^MODE_ARM | MODE_THUMB
+			continue
+		}
+		if syntax, ok := syntaxM[part]; ok {
+			target.Syntax = syntax
+		}
+	}
+	if checkTarget(target.Arch, target.Mode) != nil {
+		return Target{}, fmt.Errorf("target %q is not supported by keystone", triple)
+	}
+	return target, nil
+}
+
+// parseARM is used to parse the ARM arch name like "armv7", "armeb" and "thumbv8".
+func parseARM(name string) (Target, bool) {
+	mode := MODE_LITTLE_ENDIAN
+	switch {
+	case strings.HasSuffix(name, "eb"), strings.HasSuffix(name, "be"):
+		mode = MODE_BIG_ENDIAN
+		name = name[:len(name)-2]
+	case strings.HasSuffix(name, "el"):
+		name = name[:len(name)-2]
+	}
+	m := armRe.FindStringSubmatch(name)
+	if m == nil {
+		return Target{}, false
+	}
+	if m[1] == "arm" {
+		mode |= MODE_ARM
+	} else {
+		mode |= MODE_THUMB
+	}
+	if m[2] != "" {
+		ver, _ := strconv.Atoi(m[2])
+		if ver >= 8 {
+			mode |= MODE_V8
+		}
+	}
+	return Target{Arch: ARCH_ARM, Mode: mode}, true
+}
+
+// String returns the canonical target triple, like "x86_64" and
+// "thumbv8eb", the syntax is appended like "x86_64-att" if it is set.
+// The zero Target returns an empty string.
+func (t Target) String() string {
+	if t == (Target{}) {
+		return ""
+	}
+	name := t.archName()
+	if syntax, ok := keyOf(syntaxM, t.Syntax); ok {
+		name += "-" + syntax
+	}
+	return name
+}
+
+func (t Target) archName() string {
+	if t.Arch == ARCH_ARM {
+		name := "arm"
+		if t.Mode&MODE_THUMB != 0 {
+			name = "thumb"
+		}
+		if t.Mode&MODE_V8 != 0 {
+			name += "v8"
+		}
+		if t.Mode&MODE_BIG_ENDIAN != 0 {
+			name += "eb"
+		}
+		return name
+	}
+	for _, name := range canonicalTriples {
+		target := tripleM[name]
+		if target.Arch == t.Arch && target.Mode == t.Mode {
+			return name
+		}
+	}
+	return fmt.Sprintf("%s-%s", t.Arch, t.Mode)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t Target) MarshalText() ([]byte, error) {
+	if err := checkTarget(t.Arch, t.Mode); err != nil {
+		return nil, fmt.Errorf("invalid target %s", t)
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *Target) UnmarshalText(text []byte) error {
+	target, err := ParseTarget(string(text))
+	if err != nil {
+		return err
+	}
+	*t = target
+	return nil
+}
+
+// Set implements pflag.Value.
+func (t *Target) Set(s string) error {
+	return t.UnmarshalText([]byte(s))
+}
+
+// Type implements pflag.Value.
+func (t *Target) Type() string {
+	return "target"
+}
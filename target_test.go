@@ -0,0 +1,167 @@
+package keystone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportedTargets(t *testing.T) {
+	targets := SupportedTargets()
+	require.NotEmpty(t, targets)
+
+	for _, target := range targets {
+		engine, err := NewEngine(target.Arch, target.Mode)
+		require.NoError(t, err, "%s %s", target.Arch, target.Mode)
+
+		ok, err := engine.ArchSupported(target.Arch)
+		require.NoError(t, err)
+		require.True(t, ok, "%s", target.Arch)
+
+		err = engine.Close()
+		require.NoError(t, err)
+	}
+}
+
+func TestEngine_ArchSupported(t *testing.T) {
+	engine, err := newFakeEngine(nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, engine.Close()) }()
+
+	// the fake module not export ks_arch_supported
+	require.Nil(t, engine._ksArchSupported)
+
+	calls := engine.Stats().Calls
+	ok, err := engine.ArchSupported(ARCH_MIPS)
+	require.NoError(t, err)
+	require.True(t, ok)
+	stats := engine.Stats()
+	require.Equal(t, calls["ks_open"]+1, stats.Calls["ks_open"])
+	require.Equal(t, calls["ks_close"]+1, stats.Calls["ks_close"])
+
+	ok, err = engine.ArchSupported(ARCH_MAX)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestCheckTarget(t *testing.T) {
+	err := checkTarget(ARCH_X86, MODE_64)
+	require.NoError(t, err)
+
+	err = checkTarget(ARCH_MAX, MODE_64)
+	require.ErrorIs(t, err, ErrArch)
+
+	err = checkTarget(ARCH_RISCV, MODE_THUMB)
+	require.ErrorIs(t, err, ErrMode)
+	require.EqualError(t, err, "failed to open keystone engine: invalid mode thumb for arch riscv (KS_ERR_MODE)")
+
+	err = checkTarget(ARCH_X86, MODE_32|MODE_BIG_ENDIAN)
+	require.ErrorIs(t, err, ErrMode)
+}
+
+func TestParseTarget(t *testing.T) {
+	for _, item := range []struct {
+		triple string
+		target Target
+		str    string
+	}{
+		{"x86_64", Target{Arch: ARCH_X86, Mode: MODE_64}, "x86_64"},
+		{"x86_64-pc-linux-gnu", Target{Arch: ARCH_X86, Mode: MODE_64}, "x86_64"},
+		{"amd64", Target{Arch: ARCH_X86, Mode: MODE_64}, "x86_64"},
+		{"i386", Target{Arch: ARCH_X86, Mode: MODE_32}, "i386"},
+		{"i686-att", Target{Arch: ARCH_X86, Mode: MODE_32, Syntax: OPT_SYNTAX_ATT}, "i386-att"},
+		{"aarch64", Target{Arch: ARCH_ARM64}, "aarch64"},
+		{"armv7", Target{Arch: ARCH_ARM, Mode: MODE_ARM}, "arm"},
+		{"armv7-thumb", Target{Arch: ARCH_ARM, Mode: MODE_THUMB}, "thumb"},
+		{"armv7eb", Target{Arch: ARCH_ARM, Mode: MODE_ARM | MODE_BIG_ENDIAN}, "armeb"},
+		{"armv8-thumb", Target{Arch: ARCH_ARM, Mode: MODE_THUMB | MODE_V8}, "thumbv8"},
+		{"thumbv8eb", Target{Arch: ARCH_ARM, Mode: MODE_THUMB | MODE_V8 | MODE_BIG_ENDIAN}, "thumbv8eb"},
+		{"mips", Target{Arch: ARCH_MIPS, Mode: MODE_MIPS32 | MODE_BIG_ENDIAN}, "mips"},
+		{"mipsle", Target{Arch: ARCH_MIPS, Mode: MODE_MIPS32}, "mipsel"},
+		{"mips64el", Target{Arch: ARCH_MIPS, Mode: MODE_MIPS64}, "mips64el"},
+		{"riscv64", Target{Arch: ARCH_RISCV, Mode: MODE_RISCV64}, "riscv64"},
+		{"ppc64le", Target{Arch: ARCH_PPC, Mode: MODE_PPC64}, "ppc64le"},
+		{"powerpc64", Target{Arch: ARCH_PPC, Mode: MODE_PPC64 | MODE_BIG_ENDIAN}, "ppc64"},
+		{"sparcv9", Target{Arch: ARCH_SPARC, Mode: MODE_SPARC64 | MODE_BIG_ENDIAN}, "sparc64"},
+		{"s390x", Target{Arch: ARCH_SYSTEMZ, Mode: MODE_BIG_ENDIAN}, "s390x"},
+	} {
+		target, err := ParseTarget(item.triple)
+		require.NoError(t, err, item.triple)
+		require.Equal(t, item.target, target, item.triple)
+		require.Equal(t, item.str, target.String(), item.triple)
+
+		// round trip
+		target, err = ParseTarget(target.String())
+		require.NoError(t, err, item.triple)
+		require.Equal(t, item.target, target, item.triple)
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := ParseTarget("foo")
+		require.EqualError(t, err, `unknown target "foo"`)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := ParseTarget("aarch64_be")
+		require.EqualError(t, err, `target "aarch64_be" is not supported by keystone`)
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		var target Target
+		require.Empty(t, target.String())
+	})
+
+	t.Run("text", func(t *testing.T) {
+		var target Target
+		err := target.Set("x86_64-nasm")
+		require.NoError(t, err)
+		data, err := target.MarshalText()
+		require.NoError(t, err)
+		require.Equal(t, "x86_64-nasm", string(data))
+	})
+}
+
+func TestBigEndianTargets(t *testing.T) {
+	for _, item := range []struct {
+		arch     string
+		mode     string
+		src      string
+		expected []byte
+	}{
+		{"arm", "arm", "mov r0, #1", []byte{0x01, 0x00, 0xA0, 0xE3}},
+		{"arm", "arm+be", "mov r0, #1", []byte{0xE3, 0xA0, 0x00, 0x01}},
+		{"arm", "arm+v8+be", "mov r0, #1", []byte{0xE3, 0xA0, 0x00, 0x01}},
+		{"arm", "thumb", "movs r0, #1", []byte{0x01, 0x20}},
+		{"arm", "thumb+be", "movs r0, #1", []byte{0x20, 0x01}},
+		{"arm", "thumb+v8+be", "movs r0, #1", []byte{0x20, 0x01}},
+		{"mips", "mips32", "addiu $2, $0, 1", []byte{0x01, 0x00, 0x02, 0x24}},
+		{"mips", "mips32+be", "addiu $2, $0, 1", []byte{0x24, 0x02, 0x00, 0x01}},
+		{"mips", "mips64+be", "daddiu $2, $0, 1", []byte{0x64, 0x02, 0x00, 0x01}},
+		{"ppc", "ppc32+be", "li 3, 1", []byte{0x38, 0x60, 0x00, 0x01}},
+		{"ppc", "ppc64+be", "li 3, 1", []byte{0x38, 0x60, 0x00, 0x01}},
+		{"ppc", "ppc64", "li 3, 1", []byte{0x01, 0x00, 0x60, 0x38}},
+		{"sparc", "sparc32+be", "mov 1, %o0", []byte{0x90, 0x10, 0x20, 0x01}},
+		{"sparc", "sparc64+be", "mov 1, %o0", []byte{0x90, 0x10, 0x20, 0x01}},
+		{"systemz", "be", "lgr %r1, %r2", []byte{0xB9, 0x04, 0x00, 0x12}},
+		// hexagon only has the big endian mode, but the output is little endian
+		{"hexagon", "be", "v23.w=vavg(v11.w,v2.w):rnd", []byte{0x17, 0xC2, 0x0B, 0x1C}},
+	} {
+		name := item.arch + " " + item.mode
+		t.Run(name, func(t *testing.T) {
+			arch, err := ParseArch(item.arch)
+			require.NoError(t, err)
+			mode, err := ParseMode(item.mode)
+			require.NoError(t, err)
+
+			engine, err := NewEngine(arch, mode)
+			require.NoError(t, err)
+
+			inst, err := engine.Assemble(item.src, 0)
+			require.NoError(t, err)
+			require.Equal(t, item.expected, inst)
+
+			err = engine.Close()
+			require.NoError(t, err)
+		})
+	}
+}